@@ -1,84 +1,641 @@
 package main
 
 import (
-	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	context "golang.org/x/net/context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errLoggingDisabled and errStatsDisabled are returned by the admin methods
+// of a pipeline turned off
+var (
+	errLoggingDisabled = status.Error(codes.Unimplemented, "logging is disabled")
+	errStatsDisabled   = status.Error(codes.Unimplemented, "statistics are disabled")
 )
 
 func (s *service) Logging(nothing *Nothing, srv Admin_LoggingServer) error {
+	if s.cfg.LoggingDisabled {
+		return errLoggingDisabled
+	}
 
 	listener := listener{
-		logsCh:  make(chan *logMsg),
-		closeCh: make(chan struct{}),
+		logsCh:      make(chan *logMsg, s.cfg.ListenerBufferSize),
+		closeCh:     make(chan struct{}),
+		doneCh:      make(chan struct{}),
+		mode:        s.cfg.LogDelivery,
+		sendTimeout: s.cfg.LogSendTimeout,
+		filter:      logFilter{consumer: nothing.Consumer, methodPrefix: nothing.MethodPrefix},
+	}
+	// doneCh is closed first, so a broadcast stuck on this listener
+	// releases the lock removeListener needs
+	defer s.removeListener(&listener)
+	defer close(listener.doneCh)
+
+	history, err := s.addListener(&listener)
+	if err != nil {
+		return err
+	}
+	defer s.streams.Done()
+
+	send := func(event *Event) error {
+		srv.Send(event)
+		return nil
+	}
+	// final sends the last events once closeCh is closed
+	final := func(events []*Event) {
+		for _, event := range events {
+			srv.Send(event)
+		}
+	}
+	if listener.mode == CloseOnFull {
+		// a Send stuck on a client that does not read must not keep us
+		// from seeing closeCh, so sending goes on in its own goroutine
+		events := make(chan *Event)
+		sent := make(chan struct{})
+		stopOnce := &sync.Once{}
+		stop := func() { stopOnce.Do(func() { close(events) }) }
+		defer stop()
+		go func() {
+			defer close(sent)
+			for event := range events {
+				srv.Send(event)
+			}
+		}()
+
+		send = func(event *Event) error {
+			select {
+			case events <- event:
+				return nil
+			case <-listener.closeCh:
+				return listener.closeErr
+			case <-srv.Context().Done():
+				return nil
+			}
+		}
+		// the stream may not end before the goroutine sent them, it is
+		// waited for up to sendTimeout
+		final = func(last []*Event) {
+			timer := time.NewTimer(listener.sendTimeout)
+			defer timer.Stop()
+
+			for _, event := range last {
+				select {
+				case events <- event:
+				case <-timer.C:
+					return
+				case <-srv.Context().Done():
+					return
+				}
+			}
+			stop()
+			select {
+			case <-sent:
+			case <-timer.C:
+			}
+		}
+	}
+
+	// on shutdown what is already in logsCh goes out, then the sentinel
+	closed := func() error {
+		if !listener.shutdown {
+			return listener.closeErr
+		}
+
+		last := []*Event{}
+		for {
+			select {
+			case logMsg := <-listener.logsCh:
+				last = append(last, eventFromLog(logMsg))
+				continue
+			default:
+			}
+			break
+		}
+		last = append(last, &Event{
+			Timestamp: time.Now().Unix(),
+			Method:    shutdownEventMethod,
+			Host:      s.addr,
+		})
+		final(last)
+
+		return listener.closeErr
+	}
+
+	// live messages wait in logsCh until the replay is done
+	for _, logMsg := range history {
+		if !listener.filter.match(logMsg) {
+			continue
+		}
+		if err := send(eventFromLog(logMsg)); err != nil {
+			return err
+		}
 	}
-	s.addListener(&listener)
 
 	for {
 		select {
 		case logMsg := <-listener.logsCh:
-			event := &Event{
-				Consumer: logMsg.consumerName,
-				Method:   logMsg.methodName,
-				Host:     "127.0.0.1:8083",
+			if err := send(eventFromLog(logMsg)); err != nil {
+				return err
 			}
-			srv.Send(event)
 
 		case <-listener.closeCh:
+			return closed()
+
+		case <-srv.Context().Done():
+			return nil
+		}
+	}
+}
+
+// DrainEvents sends everything kept in the log history, clearing it, and
+// completes. Unlike Logging there is no live tail
+func (s *service) DrainEvents(nothing *Nothing, srv Admin_DrainEventsServer) error {
+	if s.cfg.LoggingDisabled {
+		return errLoggingDisabled
+	}
+
+	for _, logMsg := range s.drainLogs() {
+		err := srv.Send(eventFromLog(logMsg))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AuditAccess streams the ACL decision for every call, allowed or denied
+func (s *service) AuditAccess(nothing *Nothing, srv Admin_AuditAccessServer) error {
+	al := auditListener{
+		eventsCh: make(chan *AccessEvent, s.cfg.ListenerBufferSize),
+		closeCh:  make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	defer s.removeAuditListener(&al)
+	defer close(al.doneCh)
+
+	err := s.addAuditListener(&al)
+	if err != nil {
+		return err
+	}
+	defer s.streams.Done()
+
+	for {
+		select {
+		case event := <-al.eventsCh:
+			srv.Send(event)
+
+		case <-al.closeCh:
+			return nil
+
+		case <-srv.Context().Done():
 			return nil
 		}
 	}
 }
 
+// Health reports SERVING while the server is up and NOT_SERVING before it
+// starts, once it drains and once shutdown began
+func (s *service) Health(ctx context.Context, nothing *Nothing) (*HealthStatus, error) {
+	status := HealthStatus_SERVING
+
+	select {
+	case <-s.serving:
+	default:
+		status = HealthStatus_NOT_SERVING
+	}
+
+	s.m.RLock()
+	if s.shuttingDown || s.draining {
+		status = HealthStatus_NOT_SERVING
+	}
+	s.m.RUnlock()
+
+	return &HealthStatus{Status: status}, nil
+}
+
+// eventFromLog builds the Event for logMsg. Timestamp is in unix seconds
+// of when the call was intercepted
+func eventFromLog(logMsg *logMsg) *Event {
+	return &Event{
+		Timestamp: logMsg.at.Unix(),
+		Consumer:  logMsg.consumerName,
+		Method:    logMsg.methodName,
+		Host:      logMsg.host,
+		TraceId:   logMsg.traceID,
+		RequestId: logMsg.requestID,
+		Code:      int32(logMsg.code),
+	}
+}
+
 func (s *service) Statistics(interval *StatInterval, srv Admin_StatisticsServer) error {
+	if s.cfg.StatsDisabled {
+		return errStatsDisabled
+	}
+
+	period := statPeriod(interval)
+	if period == 0 {
+		return status.Error(codes.InvalidArgument, "interval must be positive")
+	}
+	if err := checkStatPeriod(period); err != nil {
+		return err
+	}
+
+	return s.streamStats(srv, period, interval.SendInitial, newStatFilter(interval), nil)
+}
+
+// StatisticsInteractive is Statistics where the client sends the interval
+// and may send new ones at any time, each restarts the window. A zero
+// interval pauses the reports, calls are still counted and go out with
+// the first report after resuming. The filter is taken from the first
+// interval, later ones only change the period
+func (s *service) StatisticsInteractive(srv Admin_StatisticsInteractiveServer) error {
+	if s.cfg.StatsDisabled {
+		return errStatsDisabled
+	}
 
-	closeCh := make(chan struct{})
+	interval, err := srv.Recv()
+	if err != nil {
+		return err
+	}
+	if err := checkStatPeriod(statPeriod(interval)); err != nil {
+		return err
+	}
+
+	updates := make(chan time.Duration)
+	done := make(chan struct{})
+	defer close(done)
 
-	ticker := time.NewTicker(time.Second * time.Duration(interval.IntervalSeconds))
+	go func() {
+		for {
+			interval, err := srv.Recv()
+			if err != nil {
+				// the client stopped sending, the last interval stays
+				return
+			}
+			select {
+			case updates <- statPeriod(interval):
+			case <-done:
+				return
+			}
+		}
+	}()
 
+	return s.streamStats(srv, statPeriod(interval), interval.SendInitial, newStatFilter(interval), updates)
+}
+
+// statStream is the sending side of both statistics streams
+type statStream interface {
+	Send(*Stat) error
+	Context() context.Context
+}
+
+// statFilter limits a statistics stream to one consumer and/or method,
+// empty fields match everything
+type statFilter struct {
+	consumer string
+	method   string
+}
+
+func newStatFilter(interval *StatInterval) statFilter {
+	return statFilter{consumer: interval.Consumer, method: interval.Method}
+}
+
+func (f statFilter) match(statMsg *statMsg) bool {
+	return (f.consumer == "" || f.consumer == statMsg.consumerName) &&
+		(f.method == "" || f.method == statMsg.methodName)
+}
+
+// streamStats sends a Stat to stream every period, a new period from
+// updates restarts the window and zero pauses it. With initial an empty
+// Stat goes out first, once the stream counts calls. Only calls matching
+// filter are counted. A report not sent within StatSendTimeout ends the
+// stream
+func (s *service) streamStats(stream statStream, period time.Duration, initial bool, filter statFilter, updates <-chan time.Duration) error {
 	sl := statListener{
-		statCh:  make(chan *statMsg, 0),
+		statCh:  make(chan *statMsg, s.cfg.ListenerBufferSize),
 		closeCh: make(chan struct{}, 0),
+		doneCh:  make(chan struct{}),
+	}
+	// same order as in Logging, doneCh is closed before removal
+	defer s.removeStatListener(&sl)
+	defer close(sl.doneCh)
+
+	err := s.addStatListener(&sl)
+	if err != nil {
+		return err
+	}
+	defer s.streams.Done()
+
+	// a Send stuck on a client that does not read is given up on, the
+	// windows would drift behind it otherwise
+	send := func(stat *Stat) error {
+		sent := make(chan struct{})
+		go func() {
+			stream.Send(stat)
+			close(sent)
+		}()
+
+		timer := time.NewTimer(s.cfg.StatSendTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-sent:
+		case <-stream.Context().Done():
+		case <-timer.C:
+			return status.Error(codes.DeadlineExceeded, "statistics stream is too slow")
+		}
+		return nil
 	}
 
-	s.addStatListener(&sl)
+	if initial {
+		stat := newStat()
+		stat.Timestamp = time.Now().Unix()
+		s.setGauges(stat)
+		if err := send(stat); err != nil {
+			return err
+		}
+	}
 
-	c := make(map[string]uint64)
-	m := make(map[string]uint64)
+	// messages are stamped when intercepted, so each one lands in exactly
+	// one window even if it reaches us after the tick
+	var windowEnd time.Time
+
+	// ticks is nil while paused
+	var ticker *time.Ticker
+	var ticks <-chan time.Time
+	restart := func(p time.Duration) {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, ticks = nil, nil
+		}
+		period = p
+		if period <= 0 {
+			return
+		}
+		ticker = time.NewTicker(period)
+		ticks = ticker.C
+		windowEnd = time.Now().Add(period)
+	}
+	restart(period)
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+
+	cur := newStatsAccumulator(s.cfg.MaxTrackedConsumers)
+	next := newStatsAccumulator(s.cfg.MaxTrackedConsumers)
+
+	count := func(statMsg *statMsg) {
+		if !filter.match(statMsg) {
+			return
+		}
+		acc := cur
+		if ticks != nil && !statMsg.at.Before(windowEnd) {
+			acc = next
+		}
+		acc.Add(statMsg.consumerName, statMsg.methodName, statMsg.code)
+	}
 
 	for {
 		select {
-		case <-ticker.C:
-			statEvent := &Stat{
-				Timestamp:  0,
-				ByMethod:   m,
-				ByConsumer: c,
+		case tick := <-ticks:
+			// pick up whatever is already waiting for this window
+		drain:
+			for {
+				select {
+				case statMsg := <-sl.statCh:
+					count(statMsg)
+				default:
+					break drain
+				}
 			}
 
-			srv.Send(statEvent)
-
-			c = make(map[string]uint64)
-			m = make(map[string]uint64)
+			stat := cur.SnapshotAndReset()
+			// Timestamp is the unix time the window was closed at
+			stat.Timestamp = tick.Unix()
+			stat.Dropped = atomic.SwapUint64(&sl.dropped, 0)
+			s.setGauges(stat)
+			stat.LifetimeByConsumer = s.sinceStart.ByConsumer()
+			if filter.consumer != "" {
+				lifetime := stat.LifetimeByConsumer[filter.consumer]
+				stat.LifetimeByConsumer = map[string]uint64{}
+				if lifetime > 0 {
+					stat.LifetimeByConsumer[filter.consumer] = lifetime
+				}
+			}
 
-		case statMsg := <-sl.statCh:
-			_, ok := c[statMsg.consumerName]
-			if !ok {
-				c[statMsg.consumerName] = 1
-			} else {
-				c[statMsg.consumerName]++
+			if err := send(stat); err != nil {
+				return err
 			}
 
-			_, ok = m[statMsg.methodName]
-			if !ok {
-				m[statMsg.methodName] = 1
-			} else {
-				m[statMsg.methodName]++
+			cur, next = next, cur
+			windowEnd = windowEnd.Add(period)
+
+		case p := <-updates:
+			if err := checkStatPeriod(p); err != nil {
+				return err
 			}
+			restart(p)
 
-		case <-closeCh:
-			fmt.Println("CLOSED")
+		case statMsg := <-sl.statCh:
+			count(statMsg)
+
+		case <-sl.closeCh:
+			return nil
+
+		case <-stream.Context().Done():
 			return nil
 		}
 	}
+}
+
+// setGauges fills in the open streams and connections of stat
+func (s *service) setGauges(stat *Stat) {
+	stat.ActiveLoggingStreams = uint64(s.ListenerCount())
+	stat.ActiveStatStreams = uint64(s.StatListenerCount())
+	stat.ActiveConnections = uint64(s.ConnectionCount())
+}
+
+// maxStatPeriod is the longest statistics window accepted
+const maxStatPeriod = 24 * time.Hour
+
+// checkStatPeriod rejects windows over maxStatPeriod, and negative ones an
+// overflowing interval ends up as. Zero is left to the caller
+func checkStatPeriod(period time.Duration) error {
+	if period < 0 || period > maxStatPeriod {
+		return status.Error(codes.InvalidArgument, "interval is out of range")
+	}
 
 	return nil
 }
+
+// statPeriod is the window length, IntervalMs takes precedence over
+// IntervalSeconds for sub-second windows
+func statPeriod(interval *StatInterval) time.Duration {
+	if interval.IntervalMs > 0 {
+		return time.Millisecond * time.Duration(interval.IntervalMs)
+	}
+
+	return time.Second * time.Duration(interval.IntervalSeconds)
+}
+
+// Snapshot returns the counts since the server started in one call, a
+// Statistics stream is not needed. The Snapshot call itself is counted
+// after it returns
+func (s *service) Snapshot(ctx context.Context, nothing *Nothing) (*Stat, error) {
+	if s.cfg.StatsDisabled {
+		return nil, errStatsDisabled
+	}
+
+	stat := s.sinceStart.Snapshot()
+	stat.Timestamp = time.Now().Unix()
+
+	return stat, nil
+}
+
+// ListConsumers returns what ActiveConsumers does. The caller shows up from
+// its next call on, this one is not counted yet
+func (s *service) ListConsumers(ctx context.Context, nothing *Nothing) (*ConsumerList, error) {
+	if s.cfg.StatsDisabled {
+		return nil, errStatsDisabled
+	}
+
+	return &ConsumerList{Consumers: s.ActiveConsumers()}, nil
+}
+
+// EffectiveACL tells what a consumer may call. Like any admin method it
+// needs an ACL entry, whoever has one may ask about any consumer
+func (s *service) EffectiveACL(ctx context.Context, name *ConsumerName) (*ACLEntries, error) {
+	s.m.RLock()
+	own, _ := s.consumerRules(name.Consumer)
+	rules := make([]string, 0, len(own)+len(s.aclStorage[aclAnyConsumer]))
+	rules = append(rules, own...)
+	if name.Consumer != aclAnyConsumer {
+		rules = append(rules, s.aclStorage[aclAnyConsumer]...)
+	}
+	s.m.RUnlock()
+
+	methods := []string{}
+	for _, method := range serviceMethods() {
+		if err := s.checkBizPermission(name.Consumer, method); err == nil {
+			methods = append(methods, method)
+		}
+	}
+
+	return &ACLEntries{Rules: rules, Methods: methods}, nil
+}
+
+func newStat() *Stat {
+	return &Stat{
+		ByMethod:         make(map[string]uint64),
+		ByConsumer:       make(map[string]uint64),
+		ErrorsByMethod:   make(map[string]uint64),
+		ErrorsByConsumer: make(map[string]uint64),
+	}
+}
+
+// statsAccumulator collects the counts of a stat window. It is safe for
+// concurrent use
+type statsAccumulator struct {
+	m            *sync.Mutex
+	stat         *Stat
+	maxConsumers int
+}
+
+func newStatsAccumulator(maxConsumers int) *statsAccumulator {
+	return &statsAccumulator{
+		m:            &sync.Mutex{},
+		stat:         newStat(),
+		maxConsumers: maxConsumers,
+	}
+}
+
+// Add counts a call in By*, a failed one is also counted in ErrorsBy*
+func (a *statsAccumulator) Add(consumer, method string, code codes.Code) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	consumer = trackedConsumer(a.stat.ByConsumer, consumer, a.maxConsumers)
+
+	a.stat.ByConsumer[consumer]++
+	a.stat.ByMethod[method]++
+
+	if code != codes.OK {
+		a.stat.ErrorsByConsumer[consumer]++
+		a.stat.ErrorsByMethod[method]++
+	}
+}
+
+// Snapshot returns a copy of the counts so far
+func (a *statsAccumulator) Snapshot() *Stat {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	stat := newStat()
+	for k, v := range a.stat.ByMethod {
+		stat.ByMethod[k] = v
+	}
+	for k, v := range a.stat.ByConsumer {
+		stat.ByConsumer[k] = v
+	}
+	for k, v := range a.stat.ErrorsByMethod {
+		stat.ErrorsByMethod[k] = v
+	}
+	for k, v := range a.stat.ErrorsByConsumer {
+		stat.ErrorsByConsumer[k] = v
+	}
+
+	return stat
+}
+
+// ByConsumer returns a copy of the counts by consumer so far
+func (a *statsAccumulator) ByConsumer() map[string]uint64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	byConsumer := make(map[string]uint64, len(a.stat.ByConsumer))
+	for k, v := range a.stat.ByConsumer {
+		byConsumer[k] = v
+	}
+
+	return byConsumer
+}
+
+// SnapshotAndReset returns the counts so far and starts over
+func (a *statsAccumulator) SnapshotAndReset() *Stat {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	stat := a.stat
+	a.stat = newStat()
+
+	return stat
+}
+
+// trackedConsumer returns the key to count consumer under in counts,
+// at most maxConsumers get their own key
+func trackedConsumer(counts map[string]uint64, consumer string, maxConsumers int) string {
+	if maxConsumers <= 0 {
+		return consumer
+	}
+	if _, ok := counts[consumer]; ok {
+		return consumer
+	}
+
+	tracked := len(counts)
+	if _, ok := counts[otherConsumers]; ok {
+		tracked--
+	}
+	if tracked >= maxConsumers {
+		return otherConsumers
+	}
+
+	return consumer
+}
@@ -2,16 +2,44 @@ package main
 
 import (
 	context "golang.org/x/net/context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func (s *service) Check(ctx context.Context, n *Nothing) (*Nothing, error) {
-	return &Nothing{}, nil
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return &Nothing{Dummy: n.GetDummy()}, nil
 }
 
 func (s *service) Add(ctx context.Context, n *Nothing) (*Nothing, error) {
-	return &Nothing{}, nil
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return &Nothing{Dummy: n.GetDummy()}, nil
 }
 
 func (s *service) Test(ctx context.Context, n *Nothing) (*Nothing, error) {
-	return &Nothing{}, nil
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return &Nothing{Dummy: n.GetDummy()}, nil
+}
+
+// contextError turns an expired or cancelled ctx into a status error,
+// nil while the call may go on
+func contextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	default:
+		return status.Error(codes.Canceled, "call cancelled")
+	}
 }
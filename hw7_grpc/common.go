@@ -1,13 +1,22 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	context "golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -16,38 +25,383 @@ const (
 	logger   = "logger"
 )
 
-func getConsumerNameFromContext(ctx context.Context) (string, error) {
+const (
+	accessAllow = "allow"
+	accessDeny  = "deny"
+)
+
+// aclDenyPrefix marks an ACL entry denying the methods it matches
+const aclDenyPrefix = "!"
+
+// aclAnyConsumer is the ACL key whose entries apply to every consumer.
+// Other keys may be path.Match patterns like svc-payments-*, see
+// consumerRules
+const aclAnyConsumer = "*"
+
+func (srv *service) getConsumerNameFromContext(ctx context.Context) (string, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return "", grpc.Errorf(codes.Unauthenticated, "can not get metadata")
 	}
-	consumer, ok := md["consumer"]
-	if !ok || len(consumer) != 1 {
+	if srv.cfg.TokenValidator != nil {
+		return srv.consumerFromToken(ctx, md)
+	}
+	consumer := dedupConsumers(md["consumer"])
+	if len(consumer) == 0 {
 		return "", grpc.Errorf(codes.Unauthenticated, "can not get metadata")
 	}
+	if len(consumer) > 1 && srv.cfg.MultipleConsumers != FirstConsumer {
+		return "", grpc.Errorf(codes.Unauthenticated, "conflicting consumer values: %s", strings.Join(consumer, ", "))
+	}
 
 	return consumer[0], nil
 }
 
-func (srv *service) checkBizPermission(consumer, method string) error {
-	allowedMethods, ok := srv.aclStorage[consumer]
+// bearerPrefix starts the authorization value TokenValidator gets the rest of
+const bearerPrefix = "Bearer "
+
+// consumerFromToken is the consumer TokenValidator finds for the bearer
+// token of the call, the consumer header is not looked at
+func (srv *service) consumerFromToken(ctx context.Context, md metadata.MD) (string, error) {
+	auth := md["authorization"]
+	if len(auth) != 1 || !strings.HasPrefix(auth[0], bearerPrefix) {
+		return "", grpc.Errorf(codes.Unauthenticated, "missing bearer token")
+	}
+
+	consumer, err := srv.cfg.TokenValidator(ctx, strings.TrimPrefix(auth[0], bearerPrefix))
+	if err != nil || consumer == "" {
+		return "", grpc.Errorf(codes.Unauthenticated, "invalid token")
+	}
+
+	return consumer, nil
+}
+
+// dedupConsumers drops repeated values, keeping the order
+func dedupConsumers(values []string) []string {
+	result := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		result = append(result, v)
+	}
+
+	return result
+}
+
+// getTraceIDFromContext takes the trace id from a W3C traceparent header,
+// falling back to x-trace-id. Empty when the call is not traced
+func getTraceIDFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return grpc.Errorf(codes.Unauthenticated, "permission denied")
+		return ""
+	}
+
+	// traceparent is version-traceid-spanid-flags
+	if tp := md["traceparent"]; len(tp) > 0 {
+		parts := strings.Split(tp[0], "-")
+		if len(parts) == 4 && len(parts[1]) == 32 {
+			return parts[1]
+		}
+	}
+
+	if id := md["x-trace-id"]; len(id) > 0 {
+		return id[0]
 	}
 
+	return ""
+}
+
+// getHostFromContext returns the caller address, or the address the
+// service listens on when the peer is unknown
+func (srv *service) getHostFromContext(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return srv.addr
+	}
+
+	return p.Addr.String()
+}
+
+// requestIDHeader is read from the call and always sent back in the header
+const requestIDHeader = "x-request-id"
+
+// getRequestIDFromContext returns the id the client sent or a new random one
+func getRequestIDFromContext(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if id := md[requestIDHeader]; len(id) > 0 && id[0] != "" {
+			return id[0]
+		}
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request id of the call a handler serves
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// withRequestID makes the id available to the handler and passes it on
+// to the calls the handler makes
+func withRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return metadata.AppendToOutgoingContext(ctx, requestIDHeader, id)
+}
+
+type consumerKey struct{}
+
+// ConsumerFromContext returns the consumer a call was authorized for.
+// It works for handlers even when the raw metadata was stripped
+func ConsumerFromContext(ctx context.Context) (string, bool) {
+	consumer, ok := ctx.Value(consumerKey{}).(string)
+	return consumer, ok
+}
+
+// handlerContext prepares the context passed down to the handler
+func (srv *service) handlerContext(ctx context.Context, consumer string) context.Context {
+	ctx = context.WithValue(ctx, consumerKey{}, consumer)
+
+	if srv.cfg.StripConsumerFromHandlerContext {
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+		delete(md, "consumer")
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+
+	return ctx
+}
+
+type serverStreamWithContext struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *serverStreamWithContext) Context() context.Context {
+	return ss.ctx
+}
+
+// countingServerStream counts messages going through a stream
+type countingServerStream struct {
+	grpc.ServerStream
+	sent     uint64
+	received uint64
+}
+
+func (cs *countingServerStream) SendMsg(m interface{}) error {
+	err := cs.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddUint64(&cs.sent, 1)
+	}
+	return err
+}
+
+func (cs *countingServerStream) RecvMsg(m interface{}) error {
+	err := cs.ServerStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddUint64(&cs.received, 1)
+	}
+	return err
+}
+
+func (srv *service) checkBizPermission(consumer, method string) error {
+	_, err := srv.permission(consumer, method)
+	return err
+}
+
+// consumerRules returns the ACL entries of consumer: its own key when
+// there is one, otherwise the most specific glob key matching it. Entries
+// of aclAnyConsumer are not included. srv.m has to be held
+func (srv *service) consumerRules(consumer string) ([]string, bool) {
+	if rules, ok := srv.aclStorage[consumer]; ok {
+		return rules, true
+	}
+
+	for _, pattern := range srv.consumerGlobs {
+		if ok, _ := path.Match(pattern, consumer); ok {
+			return srv.aclStorage[pattern], true
+		}
+	}
+
+	return nil, false
+}
+
+// aclConsumerGlobs picks the consumer keys of acl that are path.Match
+// patterns, longer ones first as the more specific
+func aclConsumerGlobs(acl map[string][]string) []string {
+	globs := []string{}
+	for consumer := range acl {
+		if consumer != aclAnyConsumer && strings.ContainsAny(consumer, "*?[") {
+			globs = append(globs, consumer)
+		}
+	}
+
+	sort.Slice(globs, func(i, j int) bool {
+		if len(globs[i]) != len(globs[j]) {
+			return len(globs[i]) > len(globs[j])
+		}
+		return globs[i] < globs[j]
+	})
+
+	return globs
+}
+
+// permission is checkBizPermission that also tells whether the call was
+// let through by a temporary grant only
+func (srv *service) permission(consumer, method string) (temporary bool, err error) {
+	srv.m.RLock()
+	own, known := srv.consumerRules(consumer)
+	everyone := srv.aclStorage[aclAnyConsumer]
+	srv.m.RUnlock()
+
+	// the consumer gets the union of its own entries and the wildcard ones,
+	// a deny from either side wins
+	allowedMethods := make([]string, 0, len(own)+len(everyone))
+	allowedMethods = append(allowedMethods, own...)
+	allowedMethods = append(allowedMethods, everyone...)
+
+	// deny entries win over any allow, temporary grants included
 	for _, m := range allowedMethods {
-		//check if everything allowed
-		splitted := strings.Split(m, "/")
-		if len(splitted) == 3 && splitted[2] == "*" {
-			return nil
+		if strings.HasPrefix(m, aclDenyPrefix) && srv.aclMatch(strings.TrimPrefix(m, aclDenyPrefix), method) {
+			return false, permissionDenied(consumer, method)
+		}
+	}
+
+	for _, m := range allowedMethods {
+		if !strings.HasPrefix(m, aclDenyPrefix) && srv.aclMatch(m, method) {
+			return false, nil
+		}
+	}
+
+	if srv.hasTempGrant(consumer, method) {
+		return true, nil
+	}
+
+	// a consumer missing from the ACL is not authenticated at all,
+	// a known one is just not allowed this method
+	if !known {
+		return false, status.Error(codes.Unauthenticated, "unknown consumer")
+	}
+
+	return false, permissionDenied(consumer, method)
+}
+
+// permissionDenied is the PermissionDenied error with AccessDenied attached
+func permissionDenied(consumer, method string) error {
+	st := status.New(codes.PermissionDenied, "permission denied")
+	if detailed, err := st.WithDetails(&AccessDenied{Consumer: consumer, Method: method}); err == nil {
+		st = detailed
+	}
+
+	return st.Err()
+}
+
+// aclMatch is the package aclMatch, ignoring case with CaseInsensitiveACL
+func (srv *service) aclMatch(entry, method string) bool {
+	if srv.cfg.CaseInsensitiveACL {
+		return aclMatch(strings.ToLower(entry), strings.ToLower(method))
+	}
+
+	return aclMatch(entry, method)
+}
+
+// aclMatch reports whether the ACL entry matches method. Entries are an exact
+// method, a whole service like /main.Biz/*, a package like /main.* or *.
+// Deny entries are matched without their aclDenyPrefix
+func aclMatch(entry, method string) bool {
+	if entry == "*" || entry == method {
+		return true
+	}
+
+	splitted := strings.Split(entry, "/")
+
+	//check if the whole service allowed
+	if len(splitted) == 3 && splitted[2] == "*" {
+		return strings.HasPrefix(method, strings.TrimSuffix(entry, "*"))
+	}
+
+	//check if the whole package allowed
+	if len(splitted) == 2 && strings.HasSuffix(entry, ".*") {
+		return strings.HasPrefix(method, strings.TrimSuffix(entry, "*"))
+	}
+
+	return false
+}
+
+// GrantTemporary allows consumer to call method until ttl passes,
+// on top of whatever the ACL already permits
+func (srv *service) GrantTemporary(consumer, method string, ttl time.Duration) {
+	srv.m.Lock()
+	srv.tempGrants[consumer] = append(srv.tempGrants[consumer], tempGrant{
+		method:  method,
+		expires: time.Now().Add(ttl),
+	})
+	srv.m.Unlock()
+}
+
+func (srv *service) hasTempGrant(consumer, method string) bool {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	now := time.Now()
+	granted := false
+	alive := srv.tempGrants[consumer][:0]
+	for _, g := range srv.tempGrants[consumer] {
+		if now.After(g.expires) {
+			continue
+		}
+		alive = append(alive, g)
+		if g.method == method || srv.cfg.CaseInsensitiveACL && strings.EqualFold(g.method, method) {
+			granted = true
 		}
+	}
+
+	if len(alive) == 0 {
+		delete(srv.tempGrants, consumer)
+	} else {
+		srv.tempGrants[consumer] = alive
+	}
+
+	return granted
+}
+
+const (
+	aclRolesKey     = "roles"
+	aclConsumersKey = "consumers"
+)
+
+// validACLEntry reports whether entry has one of the shapes aclMatch knows
+func validACLEntry(entry string) bool {
+	entry = strings.TrimPrefix(entry, aclDenyPrefix)
+	if entry == "*" {
+		return true
+	}
+
+	parts := strings.Split(entry, "/")
+	switch {
+	// /pkg.*
+	case len(parts) == 2 && parts[0] == "":
+		pkg := strings.TrimSuffix(parts[1], ".*")
+		return pkg != parts[1] && pkg != "" && !strings.Contains(pkg, "*")
 
-		if m == method {
-			return nil
+	// /pkg.Service/Method or /pkg.Service/*
+	case len(parts) == 3 && parts[0] == "":
+		service, method := parts[1], parts[2]
+		if service == "" || method == "" || strings.Contains(service, "*") {
+			return false
 		}
+		return method == "*" || !strings.Contains(method, "*")
 	}
 
-	return grpc.Errorf(codes.Unauthenticated, "permission denied")
+	return false
 }
 
 func parseACL(acl string) (map[string][]string, error) {
@@ -59,10 +413,23 @@ func parseACL(acl string) (map[string][]string, error) {
 		return nil, err
 	}
 
+	if isRoleACL(aclParsed) {
+		return parseRoleACL(aclParsed)
+	}
+
 	for k, v := range aclParsed {
-		var val []string
-		err := json.Unmarshal(*v, &val)
-		if err != nil {
+		// null is a consumer that may call nothing
+		val := []string{}
+		if v != nil {
+			if err := json.Unmarshal(*v, &val); err != nil {
+				return nil, fmt.Errorf("consumer %q: want a list of methods, have %s", k, *v)
+			}
+		}
+
+		if err := checkACLEntries(fmt.Sprintf("consumer %q", k), val); err != nil {
+			return nil, err
+		}
+		if err := checkConsumerPattern(k); err != nil {
 			return nil, err
 		}
 
@@ -72,29 +439,290 @@ func parseACL(acl string) (map[string][]string, error) {
 	return result, nil
 }
 
-func (srv *service) addListener(l *listener) {
+func checkACLEntries(owner string, entries []string) error {
+	for _, entry := range entries {
+		if !validACLEntry(entry) {
+			return fmt.Errorf("%s: malformed ACL entry %q, "+
+				"want *, /pkg.*, /pkg.Service/* or /pkg.Service/Method, optionally prefixed with %s",
+				owner, entry, aclDenyPrefix)
+		}
+	}
+	return nil
+}
+
+// checkConsumerPattern rejects a consumer key path.Match can not use
+func checkConsumerPattern(consumer string) error {
+	if _, err := path.Match(consumer, ""); err != nil {
+		return fmt.Errorf("consumer %q: malformed pattern: %v", consumer, err)
+	}
+	return nil
+}
+
+// isRoleACL reports whether acl uses the roles schema: a "roles" or
+// "consumers" section holding an object. In the flat format every value is
+// a list of methods, so the two can not be confused
+func isRoleACL(acl map[string]*json.RawMessage) bool {
+	for _, key := range []string{aclRolesKey, aclConsumersKey} {
+		v, ok := acl[key]
+		if ok && v != nil && strings.HasPrefix(strings.TrimSpace(string(*v)), "{") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRoleACL resolves
+//
+//	{"roles": {role: [methods]}, "consumers": {consumer: [roles]}}
+//
+// into the flat consumer -> methods form
+func parseRoleACL(acl map[string]*json.RawMessage) (map[string][]string, error) {
+	var roles, consumers map[string][]string
+
+	for k, v := range acl {
+		if v == nil {
+			continue
+		}
+		switch k {
+		case aclRolesKey:
+			if err := json.Unmarshal(*v, &roles); err != nil {
+				return nil, fmt.Errorf("roles: %v", err)
+			}
+		case aclConsumersKey:
+			if err := json.Unmarshal(*v, &consumers); err != nil {
+				return nil, fmt.Errorf("consumers: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown ACL section %q, want %q or %q", k, aclRolesKey, aclConsumersKey)
+		}
+	}
+
+	for role, methods := range roles {
+		if err := checkACLEntries(fmt.Sprintf("role %q", role), methods); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string][]string)
+	for consumer, names := range consumers {
+		if err := checkConsumerPattern(consumer); err != nil {
+			return nil, err
+		}
+		methods := []string{}
+		for _, role := range names {
+			m, ok := roles[role]
+			if !ok {
+				return nil, fmt.Errorf("consumer %q: unknown role %q", consumer, role)
+			}
+			methods = append(methods, m...)
+		}
+		result[consumer] = methods
+	}
+
+	return result, nil
+}
+
+// serviceMethods lists the full names of the Biz and Admin methods
+func serviceMethods() []string {
+	methods := []string{}
+	for _, desc := range []grpc.ServiceDesc{_Biz_serviceDesc, _Admin_serviceDesc} {
+		for _, m := range desc.Methods {
+			methods = append(methods, "/"+desc.ServiceName+"/"+m.MethodName)
+		}
+		for _, st := range desc.Streams {
+			methods = append(methods, "/"+desc.ServiceName+"/"+st.StreamName)
+		}
+	}
+	sort.Strings(methods)
+
+	return methods
+}
+
+// ReloadACL replaces the ACL rules. A bad acl is rejected and the old rules
+// stay. Rules are swapped as a whole, so a permission check sees either the
+// old or the new set, never a mix
+func (srv *service) ReloadACL(acl string) error {
+	aclParsed, err := parseACL(acl)
+	if err != nil {
+		return err
+	}
+
 	srv.m.Lock()
-	srv.listeners = append(srv.listeners, l)
+	srv.aclStorage = aclParsed
+	srv.consumerGlobs = aclConsumerGlobs(aclParsed)
+	atomic.AddUint64(&srv.aclGen, 1)
 	srv.m.Unlock()
+
+	return nil
+}
+
+// addListener registers l and returns the log history it has to replay.
+// Broadcasts record history and fan out under the read lock, so every
+// message is either in the returned history or delivered live, never both.
+// Like the other add*Listener it counts the stream in streams, the caller
+// calls streams.Done once the stream returns
+func (srv *service) addListener(l *listener) ([]*logMsg, error) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	if err := srv.acceptingListeners(); err != nil {
+		return nil, err
+	}
+	if l.logsCh == nil || l.closeCh == nil || l.doneCh == nil {
+		return nil, errListenerInvalid
+	}
+	for _, cur := range srv.listeners {
+		if cur == l {
+			return nil, errListenerRegistered
+		}
+	}
+	if srv.adminStreamsFull(len(srv.listeners)) {
+		return nil, status.Error(codes.ResourceExhausted, "too many logging streams")
+	}
+	srv.listeners = append(srv.listeners, l)
+	srv.streams.Add(1)
+
+	return srv.recentLogs(), nil
+}
+
+func (srv *service) recentLogs() []*logMsg {
+	if srv.history == nil {
+		return nil
+	}
+
+	srv.historyMu.Lock()
+	defer srv.historyMu.Unlock()
+
+	return srv.history.items()
+}
+
+func (srv *service) drainLogs() []*logMsg {
+	if srv.history == nil {
+		return nil
+	}
+
+	srv.historyMu.Lock()
+	defer srv.historyMu.Unlock()
+
+	result := srv.history.items()
+	srv.history.reset()
+
+	return result
 }
 
 func (srv *service) logsSender() {
 	for {
 		select {
 		case log := <-srv.incomingLogsCh:
-			srv.m.RLock()
-			for _, l := range srv.listeners {
-				l.logsCh <- log
+			srv.broadcastLog(log)
+			if log.done != nil {
+				close(log.done)
 			}
-			srv.m.RUnlock()
 
-		case <-srv.closeListenersCh:
-			srv.m.RLock()
-			for _, l := range srv.listeners {
-				l.closeCh <- struct{}{}
+		case <-srv.sendersStop:
+			close(srv.sinksDone)
+			return
+		}
+	}
+}
+
+func (srv *service) closeLogListeners() {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	for _, l := range srv.listeners {
+		l.closeForShutdown()
+	}
+	for _, l := range srv.auditListeners {
+		l.close()
+	}
+}
+
+func (srv *service) closeStatListeners() {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	for _, l := range srv.statListeners {
+		l.close()
+	}
+}
+
+func (srv *service) broadcastLog(log *logMsg) {
+	var closed, slow []*listener
+
+	// the history and the listeners are taken together, so a listener
+	// registering meanwhile gets the message either replayed or live. The
+	// sends happen without the lock, one that blocks on a stuck listener
+	// must not keep new ones from registering
+	srv.m.RLock()
+	if srv.history != nil {
+		srv.historyMu.Lock()
+		srv.history.add(log)
+		srv.historyMu.Unlock()
+	}
+	listeners := append([]*listener(nil), srv.listeners...)
+	srv.m.RUnlock()
+
+	for _, l := range listeners {
+		if !l.filter.match(log) {
+			continue
+		}
+		switch l.send(log) {
+		case errListenerClosed:
+			closed = append(closed, l)
+		case errListenerSlow:
+			slow = append(slow, l)
+		case errListenerFull:
+			atomic.AddUint64(&srv.droppedEvents, 1)
+		}
+	}
+
+	if len(srv.sinks) > 0 {
+		event := eventFromLog(log)
+		for _, w := range srv.sinks {
+			if !w.push(event) {
+				atomic.AddUint64(&srv.droppedEvents, 1)
 			}
-			srv.m.RUnlock()
+		}
+	}
+
+	for _, l := range closed {
+		srv.dropListener(l)
+	}
+
+	// a slow listener closed itself, so its stream may be quicker to remove
+	// it than we are. It is counted either way
+	for _, l := range slow {
+		srv.removeListener(l)
+	}
+	if len(slow) > 0 {
+		srv.m.Lock()
+		srv.droppedListeners += uint64(len(slow))
+		srv.m.Unlock()
+	}
+}
+
+// removeListener unregisters l once its Logging stream is over
+func (srv *service) removeListener(l *listener) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
 
+	for i, cur := range srv.listeners {
+		if cur == l {
+			srv.listeners = append(srv.listeners[:i], srv.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+func (srv *service) dropListener(l *listener) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	for i, cur := range srv.listeners {
+		if cur == l {
+			srv.listeners = append(srv.listeners[:i], srv.listeners[i+1:]...)
+			srv.droppedListeners++
 			return
 		}
 	}
@@ -104,25 +732,498 @@ func (srv *service) statsSender() {
 	for {
 		select {
 		case statMsg := <-srv.incomingStatCh:
-			srv.m.RLock()
-			for _, l := range srv.statListeners {
-				l.statCh <- statMsg
+			if statMsg.stream != nil {
+				srv.recordStream(statMsg)
+				continue
 			}
-			srv.m.RUnlock()
-
-		case <-srv.closeStatListenersCh:
-			srv.m.RLock()
-			for _, l := range srv.statListeners {
-				l.closeCh <- struct{}{}
+			srv.touchConsumer(statMsg)
+			srv.countTotals(statMsg)
+			srv.broadcastStat(statMsg)
+			srv.pushStatSinks(statMsg)
+			if statMsg.done != nil {
+				close(statMsg.done)
 			}
-			srv.m.RUnlock()
+
+		case <-srv.sendersStop:
+			close(srv.statSinksDone)
 			return
 		}
 	}
 }
 
-func (srv *service) addStatListener(sl *statListener) {
+// pushStatSinks hands the call to every StatSink as a Stat of its own
+func (srv *service) pushStatSinks(statMsg *statMsg) {
+	if len(srv.statSinks) == 0 {
+		return
+	}
+
+	stat := newStat()
+	stat.Timestamp = statMsg.at.Unix()
+	stat.ByMethod[statMsg.methodName] = 1
+	stat.ByConsumer[statMsg.consumerName] = 1
+	if statMsg.code != codes.OK {
+		stat.ErrorsByMethod[statMsg.methodName] = 1
+		stat.ErrorsByConsumer[statMsg.consumerName] = 1
+	}
+
+	for _, w := range srv.statSinks {
+		if !w.push(stat) {
+			atomic.AddUint64(&srv.droppedEvents, 1)
+		}
+	}
+}
+
+func (srv *service) broadcastStat(statMsg *statMsg) {
+	var closed []*statListener
+
+	srv.m.RLock()
+	statListeners := append([]*statListener(nil), srv.statListeners...)
+	srv.m.RUnlock()
+
+	for _, l := range statListeners {
+		switch l.send(statMsg) {
+		case errListenerClosed:
+			closed = append(closed, l)
+		case errListenerFull:
+			atomic.AddUint64(&srv.droppedEvents, 1)
+		}
+	}
+
+	for _, l := range closed {
+		srv.dropStatListener(l)
+	}
+}
+
+// removeStatListener unregisters sl once its Statistics stream is over
+func (srv *service) removeStatListener(sl *statListener) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	for i, cur := range srv.statListeners {
+		if cur == sl {
+			srv.statListeners = append(srv.statListeners[:i], srv.statListeners[i+1:]...)
+			return
+		}
+	}
+}
+
+func (srv *service) dropStatListener(sl *statListener) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	for i, cur := range srv.statListeners {
+		if cur == sl {
+			srv.statListeners = append(srv.statListeners[:i], srv.statListeners[i+1:]...)
+			srv.droppedListeners++
+			return
+		}
+	}
+}
+
+// DroppedListeners returns how many listeners were removed from the fan-out
+// because their stream had already gone away, or fell behind with CloseOnFull
+func (srv *service) DroppedListeners() uint64 {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	return srv.droppedListeners
+}
+
+// ListenerCount returns how many Logging streams are registered right now
+func (srv *service) ListenerCount() int {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	return len(srv.listeners)
+}
+
+// StatListenerCount returns how many Statistics streams are registered
+// right now
+func (srv *service) StatListenerCount() int {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	return len(srv.statListeners)
+}
+
+func (srv *service) countTotals(statMsg *statMsg) {
 	srv.m.Lock()
+	srv.totalRequests++
+	srv.totalByMethod[statMsg.methodName]++
+	consumer := trackedConsumer(srv.totalByConsumer, statMsg.consumerName, srv.cfg.MaxTrackedConsumers)
+	srv.totalByConsumer[consumer]++
+
+	if srv.callCounts != nil {
+		srv.callCounts[callKey{statMsg.methodName, consumer, statMsg.code}]++
+	}
+
+	if statMsg.handled {
+		h, ok := srv.latencies[statMsg.methodName]
+		if !ok {
+			h = newLatencyHistogram()
+			srv.latencies[statMsg.methodName] = h
+		}
+		h.add(statMsg.latency)
+	}
+	srv.m.Unlock()
+
+	srv.sinceStart.Add(statMsg.consumerName, statMsg.methodName, statMsg.code)
+}
+
+// StreamStat sums up finished streams of a method
+type StreamStat struct {
+	Streams  uint64
+	Sent     uint64
+	Received uint64
+	// Duration is the time all the streams were open together
+	Duration time.Duration
+	Longest  time.Duration
+}
+
+func (srv *service) recordStream(statMsg *statMsg) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	st, ok := srv.streamTotals[statMsg.methodName]
+	if !ok {
+		st = &StreamStat{}
+		srv.streamTotals[statMsg.methodName] = st
+	}
+
+	st.Streams++
+	st.Sent += statMsg.stream.sent
+	st.Received += statMsg.stream.received
+	st.Duration += statMsg.stream.duration
+	if statMsg.stream.duration > st.Longest {
+		st.Longest = statMsg.stream.duration
+	}
+}
+
+// StreamStats returns the totals of finished streams by method
+func (srv *service) StreamStats() map[string]StreamStat {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	result := make(map[string]StreamStat, len(srv.streamTotals))
+	for method, st := range srv.streamTotals {
+		result[method] = *st
+	}
+
+	return result
+}
+
+// Latency summarizes handler durations of a method since the start.
+// Percentiles are histogram bucket bounds, so they are approximate
+type Latency struct {
+	Count uint64
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	Max   time.Duration
+}
+
+// Latencies returns the handler latency of every unary method called so far
+func (srv *service) Latencies() map[string]Latency {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	result := make(map[string]Latency, len(srv.latencies))
+	for method, h := range srv.latencies {
+		result[method] = Latency{
+			Count: h.count,
+			Mean:  h.sum / time.Duration(h.count),
+			P50:   h.percentile(0.5),
+			P95:   h.percentile(0.95),
+			Max:   h.max,
+		}
+	}
+
+	return result
+}
+
+// Diagnostics is a point in time dump of the service state. The totals
+// count the same calls the Statistics stream does
+type Diagnostics struct {
+	Uptime          time.Duration
+	TotalRequests   uint64
+	ActiveListeners int
+	ByMethod        map[string]uint64
+	ByConsumer      map[string]uint64
+	ACLConsumers    int
+}
+
+// Diagnostics collects everything under a single lock, so the counters
+// and listener numbers agree with each other
+func (srv *service) Diagnostics() Diagnostics {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	d := Diagnostics{
+		Uptime:          time.Since(srv.startedAt),
+		TotalRequests:   srv.totalRequests,
+		ActiveListeners: len(srv.listeners) + len(srv.statListeners) + len(srv.auditListeners),
+		ByMethod:        make(map[string]uint64, len(srv.totalByMethod)),
+		ByConsumer:      make(map[string]uint64, len(srv.totalByConsumer)),
+		ACLConsumers:    len(srv.aclStorage),
+	}
+	for k, v := range srv.totalByMethod {
+		d.ByMethod[k] = v
+	}
+	for k, v := range srv.totalByConsumer {
+		d.ByConsumer[k] = v
+	}
+
+	return d
+}
+
+// DroppedEvents returns how many messages were not delivered to a Logging,
+// Statistics or AuditAccess stream because it fell ListenerBufferSize behind,
+// or to an EventSink or StatSink that fell sinkBufferSize behind. A stream
+// that drops stat messages undercounts its windows
+func (srv *service) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&srv.droppedEvents)
+}
+
+func (srv *service) addStatListener(sl *statListener) error {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	if err := srv.acceptingListeners(); err != nil {
+		return err
+	}
+	if sl.statCh == nil || sl.closeCh == nil || sl.doneCh == nil {
+		return errListenerInvalid
+	}
+	for _, cur := range srv.statListeners {
+		if cur == sl {
+			return errListenerRegistered
+		}
+	}
+	if srv.adminStreamsFull(len(srv.statListeners)) {
+		return status.Error(codes.ResourceExhausted, "too many statistics streams")
+	}
 	srv.statListeners = append(srv.statListeners, sl)
+	srv.streams.Add(1)
+
+	return nil
+}
+
+// acceptingListeners refuses new admin streams once StopAdmin or the
+// shutdown closed the listeners there were. The caller holds srv.m
+func (srv *service) acceptingListeners() error {
+	if srv.adminStopped {
+		return grpc.Errorf(codes.Unavailable, "admin is stopped")
+	}
+	if srv.shuttingDown {
+		return grpc.Errorf(codes.Unavailable, "server is shutting down")
+	}
+	return nil
+}
+
+// adminStreamsFull reports whether open streams of a kind reached
+// MaxAdminStreams. Dropped listeners no longer count
+func (srv *service) adminStreamsFull(open int) bool {
+	return srv.cfg.MaxAdminStreams > 0 && open >= srv.cfg.MaxAdminStreams
+}
+
+func (srv *service) addAuditListener(al *auditListener) error {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	if err := srv.acceptingListeners(); err != nil {
+		return err
+	}
+	srv.auditListeners = append(srv.auditListeners, al)
+	srv.streams.Add(1)
+
+	return nil
+}
+
+// auditAccess reports the ACL decision for a call to the AuditAccess streams,
+// denied calls included
+func (srv *service) auditAccess(consumer, method string, permErr error) {
+	event := &AccessEvent{
+		Timestamp: time.Now().Unix(),
+		Consumer:  consumer,
+		Method:    method,
+		Decision:  accessAllow,
+	}
+	if permErr != nil {
+		event.Decision = accessDeny
+	}
+
+	var closed []*auditListener
+
+	srv.m.RLock()
+	for _, l := range srv.auditListeners {
+		switch l.send(event) {
+		case errListenerClosed:
+			closed = append(closed, l)
+		case errListenerFull:
+			atomic.AddUint64(&srv.droppedEvents, 1)
+		}
+	}
+	srv.m.RUnlock()
+
+	for _, l := range closed {
+		srv.dropAuditListener(l)
+	}
+}
+
+func (srv *service) removeAuditListener(al *auditListener) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	for i, cur := range srv.auditListeners {
+		if cur == al {
+			srv.auditListeners = append(srv.auditListeners[:i], srv.auditListeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// auditRecord is a line written to AuditWriter
+type auditRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Consumer   string    `json:"consumer"`
+	Method     string    `json:"method"`
+	Peer       string    `json:"peer"`
+	Code       string    `json:"code"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// writeAudit writes the finished call to AuditWriter. Consumer is empty
+// for calls rejected before one was known
+func (srv *service) writeAudit(ctx context.Context, consumer, method string, start time.Time, err error) {
+	if srv.cfg.AuditWriter == nil {
+		return
+	}
+
+	line, _ := json.Marshal(auditRecord{
+		Timestamp:  start,
+		Consumer:   consumer,
+		Method:     method,
+		Peer:       srv.getHostFromContext(ctx),
+		Code:       grpc.Code(err).String(),
+		DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+	})
+	line = append(line, '\n')
+
+	srv.auditMu.Lock()
+	defer srv.auditMu.Unlock()
+
+	if _, err := srv.cfg.AuditWriter.Write(line); err != nil {
+		srv.cfg.Logger.Println("can not write audit record:", err)
+	}
+}
+
+func (srv *service) dropAuditListener(al *auditListener) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	for i, cur := range srv.auditListeners {
+		if cur == al {
+			srv.auditListeners = append(srv.auditListeners[:i], srv.auditListeners[i+1:]...)
+			srv.droppedListeners++
+			return
+		}
+	}
+}
+
+// StopAdmin closes every Logging, Statistics and AuditAccess stream and makes new admin
+// calls fail with Unavailable. Biz methods keep working
+func (srv *service) StopAdmin() {
+	srv.m.Lock()
+	srv.adminStopped = true
+	listeners := srv.listeners
+	statListeners := srv.statListeners
+	auditListeners := srv.auditListeners
+	srv.listeners = nil
+	srv.statListeners = nil
+	srv.auditListeners = nil
 	srv.m.Unlock()
+
+	for _, l := range listeners {
+		l.close()
+	}
+	for _, l := range statListeners {
+		l.close()
+	}
+	for _, l := range auditListeners {
+		l.close()
+	}
+}
+
+func (srv *service) isAdminStopped() bool {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	return srv.adminStopped
+}
+
+// Drain makes new Biz calls fail with Unavailable and Health report
+// NOT_SERVING, while calls already running and every admin stream carry on.
+// It does not stop anything: cancelling the context still runs the usual
+// shutdown, which closes the admin streams before GracefulStop. Draining
+// first leaves the streams time to report the last calls before that
+func (srv *service) Drain() {
+	srv.m.Lock()
+	srv.draining = true
+	srv.m.Unlock()
+}
+
+func (srv *service) isDraining() bool {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	return srv.draining
+}
+
+func (srv *service) touchConsumer(statMsg *statMsg) {
+	srv.activeMu.Lock()
+	srv.lastSeen[statMsg.consumerName] = statMsg.at
+	srv.activeMu.Unlock()
+}
+
+func (srv *service) openStream(consumer string) {
+	srv.activeMu.Lock()
+	srv.openStreams[consumer]++
+	srv.activeMu.Unlock()
+}
+
+// closeStream counts the consumer as seen when its stream ends,
+// so it stays active for one more window
+func (srv *service) closeStream(consumer string) {
+	srv.activeMu.Lock()
+	srv.openStreams[consumer]--
+	if srv.openStreams[consumer] <= 0 {
+		delete(srv.openStreams, consumer)
+	}
+	srv.lastSeen[consumer] = time.Now()
+	srv.activeMu.Unlock()
+}
+
+// ActiveConsumers returns consumers that made a call within ActiveWindow
+// or have a stream open, sorted by name
+func (srv *service) ActiveConsumers() []string {
+	srv.activeMu.Lock()
+	defer srv.activeMu.Unlock()
+
+	since := time.Now().Add(-srv.cfg.ActiveWindow)
+	result := make([]string, 0, len(srv.lastSeen)+len(srv.openStreams))
+	for consumer, at := range srv.lastSeen {
+		if at.Before(since) {
+			delete(srv.lastSeen, consumer)
+			continue
+		}
+		result = append(result, consumer)
+	}
+	for consumer := range srv.openStreams {
+		if _, ok := srv.lastSeen[consumer]; !ok {
+			result = append(result, consumer)
+		}
+	}
+	sort.Strings(result)
+
+	return result
 }
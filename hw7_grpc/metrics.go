@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+// callKey is what the request counter is labelled with
+type callKey struct {
+	method   string
+	consumer string
+	code     codes.Code
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// MetricsHandler serves the call counters, handler latencies and admin
+// stream gauges in the Prometheus text format. It answers 404 unless the service was started
+// WithMetrics
+func (srv *service) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if srv.callCounts == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(srv.metrics())
+	})
+}
+
+func (srv *service) metrics() []byte {
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+
+	buf := &bytes.Buffer{}
+
+	keys := make([]callKey, 0, len(srv.callCounts))
+	for k := range srv.callCounts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		if keys[i].consumer != keys[j].consumer {
+			return keys[i].consumer < keys[j].consumer
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	fmt.Fprintln(buf, "# HELP hw7_requests_total Calls by method, consumer and status code.")
+	fmt.Fprintln(buf, "# TYPE hw7_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(buf, "hw7_requests_total{method=\"%s\",consumer=\"%s\",code=\"%s\"} %d\n",
+			labelEscaper.Replace(k.method), labelEscaper.Replace(k.consumer), k.code, srv.callCounts[k])
+	}
+
+	methods := make([]string, 0, len(srv.latencies))
+	for method := range srv.latencies {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	fmt.Fprintln(buf, "# HELP hw7_handler_latency_seconds Handler latency of unary calls.")
+	fmt.Fprintln(buf, "# TYPE hw7_handler_latency_seconds histogram")
+	for _, method := range methods {
+		h := srv.latencies[method]
+		label := labelEscaper.Replace(method)
+
+		// prometheus buckets are cumulative
+		var cumulative uint64
+		for i, bound := range latencyBounds {
+			cumulative += h.buckets[i]
+			fmt.Fprintf(buf, "hw7_handler_latency_seconds_bucket{method=\"%s\",le=\"%g\"} %d\n",
+				label, bound.Seconds(), cumulative)
+		}
+		fmt.Fprintf(buf, "hw7_handler_latency_seconds_bucket{method=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(buf, "hw7_handler_latency_seconds_sum{method=\"%s\"} %g\n", label, h.sum.Seconds())
+		fmt.Fprintf(buf, "hw7_handler_latency_seconds_count{method=\"%s\"} %d\n", label, h.count)
+	}
+
+	fmt.Fprintln(buf, "# HELP hw7_listeners Registered Logging streams.")
+	fmt.Fprintln(buf, "# TYPE hw7_listeners gauge")
+	fmt.Fprintf(buf, "hw7_listeners %d\n", len(srv.listeners))
+	fmt.Fprintln(buf, "# HELP hw7_stat_listeners Registered Statistics streams.")
+	fmt.Fprintln(buf, "# TYPE hw7_stat_listeners gauge")
+	fmt.Fprintf(buf, "hw7_stat_listeners %d\n", len(srv.statListeners))
+
+	return buf.Bytes()
+}
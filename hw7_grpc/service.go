@@ -3,173 +3,1880 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
 	"net"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
 )
 
 var aclStorage map[string]json.RawMessage
 
+// errListenerClosed is returned when a fan-out send finds the stream
+// behind a listener has already returned
+var errListenerClosed = errors.New("listener is closed")
+
+// errListenerFull is returned when a listener has no room left in its buffer,
+// the message is dropped for it instead of blocking the callers
+var errListenerFull = errors.New("listener buffer is full")
+
+// errListenerSlow is returned by listener.send when the listener closed
+// itself for not keeping up
+var errListenerSlow = errors.New("listener is too slow")
+
+// errListenerInvalid and errListenerRegistered are returned by addListener
+// and addStatListener, which refuse a listener missing a channel the
+// fan-out needs and one that is registered already. Either is a bug on our
+// side, so the stream fails with Internal
+var (
+	errListenerInvalid    = status.Error(codes.Internal, "listener has a nil channel")
+	errListenerRegistered = status.Error(codes.Internal, "listener is already registered")
+)
+
+// listenerBufferSize is the default ListenerBufferSize
+const listenerBufferSize = 100
+
+// Config holds optional settings of the service, see the With* options
+type Config struct {
+	// CountDeniedCalls makes unary calls rejected by the ACL show up in
+	// statistics. Calls without a consumer are never counted
+	CountDeniedCalls bool
+
+	// LogHistorySize is how many recent log events a new Logging
+	// subscriber gets replayed before the live tail. Zero disables it
+	LogHistorySize int
+
+	// EventSinks get every log event alongside the Logging streams. Each
+	// sink has its own buffer, events are dropped for a sink that falls behind
+	EventSinks []EventSink
+
+	// StatSinks get a Stat for every counted call, with counts of one.
+	// Like EventSinks, each has its own buffer and drops when it falls behind
+	StatSinks []func(*Stat)
+
+	// StripConsumerFromHandlerContext removes the consumer metadata before
+	// calling handlers, they can still use ConsumerFromContext
+	StripConsumerFromHandlerContext bool
+
+	// MaxTrackedConsumers caps distinct consumers per statistics window
+	// and in Diagnostics, the rest are counted under otherConsumers.
+	// Zero means no limit. Methods need no cap, unknown ones never get here
+	MaxTrackedConsumers int
+
+	// OnServing is called once right before the server starts serving
+	OnServing func()
+
+	// OnStopped is called once after the server stopped, with the error
+	// Serve returned
+	OnStopped func(err error)
+
+	// Logger gets the service messages, nothing is logged when it is nil
+	Logger *log.Logger
+
+	// MultipleConsumers decides what to do with calls carrying different
+	// consumer values. Repeats of the same value are always accepted
+	MultipleConsumers ConsumerPolicy
+
+	// ActiveWindow is how long a consumer counts as active after its last
+	// call, defaultActiveWindow when zero
+	ActiveWindow time.Duration
+
+	// ShutdownTimeout is how long in-flight calls may run after the context
+	// is cancelled before they are cut off, defaultShutdownTimeout when zero
+	ShutdownTimeout time.Duration
+
+	// IncomingBufferSize is how many log and stat messages each may wait for
+	// the fan-out. Zero keeps calls in lockstep with the fan-out, a bigger
+	// buffer lets bursts through at the cost of memory and of Logging and
+	// Statistics streams lagging behind the calls
+	IncomingBufferSize int
+
+	// ListenerBufferSize is how many messages an admin stream may fall behind
+	// before messages get dropped for it, listenerBufferSize when zero. A
+	// bigger buffer drops less for slow streams but holds more per stream
+	ListenerBufferSize int
+
+	// RateLimit is how many unary calls per second each consumer may make,
+	// with bursts of up to RateBurst. Zero disables limiting
+	RateLimit float64
+	RateBurst int
+
+	// Metrics turns on the Prometheus text export of MetricsHandler
+	Metrics bool
+
+	// Reflection registers the gRPC reflection service, so tools like
+	// grpcurl can list the methods. It exposes the schema to anyone
+	Reflection bool
+
+	// AuditWriter gets a JSON line for every call once it is done. Lines are
+	// written one at a time on the calling goroutine, so a slow writer slows
+	// the calls down, wrap it in a bufio.Writer if needed
+	AuditWriter io.Writer
+
+	// CaseInsensitiveACL matches methods against ACL entries and temporary
+	// grants ignoring case, for clients behind proxies that change it
+	CaseInsensitiveACL bool
+
+	// MaxAdminStreams is how many Logging and how many Statistics streams
+	// may be open at once, each kind on its own. Zero is no limit
+	MaxAdminStreams int
+
+	// ACLReloadInterval is how often StartMyMicroserviceFromACLFile checks
+	// the ACL file for changes and reloads it. Zero never checks
+	ACLReloadInterval time.Duration
+
+	// LogDelivery is what happens to an event for a Logging stream whose
+	// buffer is full, DropOnFull by default. LogSendTimeout is how long
+	// CloseOnFull waits for room, defaultLogSendTimeout when zero
+	LogDelivery    DeliveryMode
+	LogSendTimeout time.Duration
+
+	// StatSendTimeout is how long a Statistics stream may take to send a
+	// report, a client that does not read for longer has its stream ended
+	// with DeadlineExceeded instead of holding up the windows.
+	// defaultStatSendTimeout when zero
+	StatSendTimeout time.Duration
+
+	// Keepalive pings idle connections and closes the ones that stop
+	// answering, which also ends the admin streams of clients that are gone.
+	// defaultKeepaliveTime is used when Time is zero, other zero fields are
+	// the grpc defaults, as is a zero KeepaliveEnforcement
+	Keepalive            keepalive.ServerParameters
+	KeepaliveEnforcement keepalive.EnforcementPolicy
+
+	// MaxRecvMsgSize and MaxSendMsgSize limit the size of a message in
+	// bytes, a bigger one fails the call with ResourceExhausted. Zero is the
+	// grpc default, 4MB to receive and no limit to send
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+
+	// Network and Address, when Network is set, are what the service
+	// listens on instead of the addr it was started with. Network is one of
+	// tcp, tcp4, tcp6 or unix
+	Network string
+	Address string
+
+	// UnaryInterceptors and StreamInterceptors run before the built-in
+	// ones, the first given is the outermost. They see every call, the ones
+	// the ACL will deny too, and may end a call themselves. Whatever they
+	// put in the context reaches the handler
+	UnaryInterceptors  []grpc.UnaryServerInterceptor
+	StreamInterceptors []grpc.StreamServerInterceptor
+
+	// TokenValidator, when set, identifies consumers by the bearer token in
+	// the authorization header instead of trusting the consumer header. It
+	// gets the context of the call and the token and returns the consumer,
+	// an error fails the call as Unauthenticated
+	TokenValidator func(ctx context.Context, token string) (consumer string, err error)
+
+	// LoggingDisabled and StatsDisabled turn the log and the stat pipelines
+	// off, calls do not report to them at all. Their admin methods return
+	// Unimplemented and whatever is fed by them stays empty: EventSinks and
+	// the log history for logging; StatSinks, metrics, latencies, stream
+	// totals and active consumers for stats
+	LoggingDisabled bool
+	StatsDisabled   bool
+
+	// HandlerTimeout is how long a Biz handler may run, its context expires
+	// after that and it returns DeadlineExceeded. Handlers have to watch
+	// the context for it to work, the Biz ones do. Zero is no limit
+	HandlerTimeout time.Duration
+
+	// AuthCacheTTL turns on a per-connection cache of the Biz permission
+	// checks the ACL allowed, a repeat call within the TTL skips the check.
+	// Up to AuthCacheSize pairs of consumer and method are kept per
+	// connection. ReloadACL flushes all the caches, temporary grants are
+	// never cached. Zero is no cache
+	AuthCacheTTL  time.Duration
+	AuthCacheSize int
+
+	// IdempotencyTTL turns on idempotency keys for Biz calls: a call that
+	// repeats the idempotency-key metadata of a successful call by the same
+	// consumer within the TTL gets its response, the handler is not run
+	// again. A repeat while the first call still runs waits for it. Failed
+	// calls are forgotten, so a retry runs. Up to IdempotencySize keys are
+	// kept. Zero is off
+	IdempotencyTTL  time.Duration
+	IdempotencySize int
+}
+
+const defaultShutdownTimeout = 5 * time.Second
+
+const defaultAuthCacheSize = 1024
+
+const defaultIdempotencySize = 10000
+
+const defaultActiveWindow = time.Minute
+
+// EventSink receives log events in process
+type EventSink interface {
+	Emit(*Event)
+}
+
+// eventSinkFunc adapts a plain function to EventSink
+type eventSinkFunc func(*Event)
+
+func (f eventSinkFunc) Emit(event *Event) {
+	f(event)
+}
+
+// otherConsumers collects consumers over the MaxTrackedConsumers limit
+const otherConsumers = "__other__"
+
+// ConsumerPolicy is how a call with several consumer values is handled
+type ConsumerPolicy int
+
+const (
+	// RejectMultipleConsumers fails the call as Unauthenticated
+	RejectMultipleConsumers ConsumerPolicy = iota
+	// FirstConsumer uses the first value, as gateways append theirs
+	FirstConsumer
+)
+
+// DeliveryMode is how events reach a Logging stream that fell behind
+type DeliveryMode int
+
+const (
+	// DropOnFull drops the event for that stream, it is counted as dropped
+	DropOnFull DeliveryMode = iota
+	// BlockOnFull waits until the stream takes the event. Every stream and
+	// every call waits along with it, use it only with trusted clients
+	BlockOnFull
+	// CloseOnFull waits up to LogSendTimeout, then ends the stream with
+	// DeadlineExceeded
+	CloseOnFull
+)
+
+const defaultLogSendTimeout = time.Second
+
+const defaultStatSendTimeout = time.Second
+
+const defaultKeepaliveTime = time.Minute
+
+// healthMethod is open to everyone, it bypasses the ACL, logs and stats
+const healthMethod = "/main.Admin/Health"
+
+// shutdownEventMethod is the method of the sentinel Event a Logging stream
+// gets last when the server shuts down
+const shutdownEventMethod = "shutdown"
+
+// reflectionService is bypassed like healthMethod, it is only registered
+// WithReflection
+const reflectionService = "/grpc.reflection.v1alpha.ServerReflection/"
+
+type Option func(*Config)
+
+func WithCountDeniedCalls() Option {
+	return func(cfg *Config) {
+		cfg.CountDeniedCalls = true
+	}
+}
+
+func WithLogHistory(size int) Option {
+	return func(cfg *Config) {
+		cfg.LogHistorySize = size
+	}
+}
+
+func WithEventSinks(sinks ...EventSink) Option {
+	return func(cfg *Config) {
+		cfg.EventSinks = append(cfg.EventSinks, sinks...)
+	}
+}
+
+// WithLogSink is WithEventSinks for a single function
+func WithLogSink(sink func(*Event)) Option {
+	return func(cfg *Config) {
+		cfg.EventSinks = append(cfg.EventSinks, eventSinkFunc(sink))
+	}
+}
+
+func WithStatSink(sink func(*Stat)) Option {
+	return func(cfg *Config) {
+		cfg.StatSinks = append(cfg.StatSinks, sink)
+	}
+}
+
+func WithStripConsumerFromHandlerContext() Option {
+	return func(cfg *Config) {
+		cfg.StripConsumerFromHandlerContext = true
+	}
+}
+
+func WithMaxTrackedConsumers(max int) Option {
+	return func(cfg *Config) {
+		cfg.MaxTrackedConsumers = max
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(cfg *Config) {
+		cfg.Logger = logger
+	}
+}
+
+func WithMultipleConsumers(policy ConsumerPolicy) Option {
+	return func(cfg *Config) {
+		cfg.MultipleConsumers = policy
+	}
+}
+
+func WithActiveWindow(window time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ActiveWindow = window
+	}
+}
+
+func WithIncomingBufferSize(size int) Option {
+	return func(cfg *Config) {
+		cfg.IncomingBufferSize = size
+	}
+}
+
+func WithListenerBufferSize(size int) Option {
+	return func(cfg *Config) {
+		cfg.ListenerBufferSize = size
+	}
+}
+
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ShutdownTimeout = timeout
+	}
+}
+
+func WithRateLimit(perSecond float64, burst int) Option {
+	return func(cfg *Config) {
+		cfg.RateLimit = perSecond
+		cfg.RateBurst = burst
+	}
+}
+
+func WithAuditWriter(w io.Writer) Option {
+	return func(cfg *Config) {
+		cfg.AuditWriter = w
+	}
+}
+
+func WithHandlerTimeout(timeout time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.HandlerTimeout = timeout
+	}
+}
+
+func WithAuthCache(ttl time.Duration, size int) Option {
+	return func(cfg *Config) {
+		cfg.AuthCacheTTL = ttl
+		cfg.AuthCacheSize = size
+	}
+}
+
+func WithIdempotency(ttl time.Duration, size int) Option {
+	return func(cfg *Config) {
+		cfg.IdempotencyTTL = ttl
+		cfg.IdempotencySize = size
+	}
+}
+
+func WithLoggingDisabled() Option {
+	return func(cfg *Config) {
+		cfg.LoggingDisabled = true
+	}
+}
+
+func WithStatsDisabled() Option {
+	return func(cfg *Config) {
+		cfg.StatsDisabled = true
+	}
+}
+
+func WithTokenValidator(validator func(ctx context.Context, token string) (string, error)) Option {
+	return func(cfg *Config) {
+		cfg.TokenValidator = validator
+	}
+}
+
+func WithKeepalive(params keepalive.ServerParameters) Option {
+	return func(cfg *Config) {
+		cfg.Keepalive = params
+	}
+}
+
+func WithNetwork(network, address string) Option {
+	return func(cfg *Config) {
+		cfg.Network = network
+		cfg.Address = address
+	}
+}
+
+func WithMaxMsgSize(recv, send int) Option {
+	return func(cfg *Config) {
+		cfg.MaxRecvMsgSize = recv
+		cfg.MaxSendMsgSize = send
+	}
+}
+
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(cfg *Config) {
+		cfg.UnaryInterceptors = append(cfg.UnaryInterceptors, interceptors...)
+	}
+}
+
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(cfg *Config) {
+		cfg.StreamInterceptors = append(cfg.StreamInterceptors, interceptors...)
+	}
+}
+
+func WithKeepaliveEnforcement(policy keepalive.EnforcementPolicy) Option {
+	return func(cfg *Config) {
+		cfg.KeepaliveEnforcement = policy
+	}
+}
+
+func WithLogDelivery(mode DeliveryMode, sendTimeout time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.LogDelivery = mode
+		cfg.LogSendTimeout = sendTimeout
+	}
+}
+
+func WithStatSendTimeout(timeout time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.StatSendTimeout = timeout
+	}
+}
+
+func WithACLReloadInterval(interval time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ACLReloadInterval = interval
+	}
+}
+
+func WithMaxAdminStreams(max int) Option {
+	return func(cfg *Config) {
+		cfg.MaxAdminStreams = max
+	}
+}
+
+func WithCaseInsensitiveACL() Option {
+	return func(cfg *Config) {
+		cfg.CaseInsensitiveACL = true
+	}
+}
+
+func WithReflection() Option {
+	return func(cfg *Config) {
+		cfg.Reflection = true
+	}
+}
+
+func WithMetrics() Option {
+	return func(cfg *Config) {
+		cfg.Metrics = true
+	}
+}
+
+func WithLifecycle(onServing func(), onStopped func(err error)) Option {
+	return func(cfg *Config) {
+		cfg.OnServing = onServing
+		cfg.OnStopped = onStopped
+	}
+}
+
 type service struct {
-	m                    *sync.RWMutex
-	incomingLogsCh       chan *logMsg
-	closeListenersCh     chan struct{}
-	listeners            []*listener
-	aclStorage           map[string][]string
-	statListeners        []*statListener
-	incomingStatCh       chan *statMsg
-	closeStatListenersCh chan struct{}
+	cfg              Config
+	m                *sync.RWMutex
+	incomingLogsCh   chan *logMsg
+	listeners        []*listener
+	aclStorage       map[string][]string
+	consumerGlobs    []string
+	statListeners    []*statListener
+	incomingStatCh   chan *statMsg
+	sendersStop      chan struct{}
+	tempGrants       map[string][]tempGrant
+	activeMu         *sync.Mutex
+	lastSeen         map[string]time.Time
+	openStreams      map[string]int
+	adminStopped     bool
+	draining         bool
+	droppedListeners uint64
+	droppedEvents    uint64
+	historyMu        *sync.Mutex
+	history          *logHistory
+	sinks            []*sinkWorker
+	sinksDone        chan struct{}
+	statSinks        []*statSinkWorker
+	statSinksDone    chan struct{}
+	startedAt        time.Time
+	totalRequests    uint64
+	totalByMethod    map[string]uint64
+	totalByConsumer  map[string]uint64
+	latencies        map[string]*latencyHistogram
+	sinceStart       *statsAccumulator
+	limiter          *rateLimiter
+	aclGen           uint64
+	activeConns      int64
+	idempotency      *idempotencyStore
+	callCounts       map[callKey]uint64
+	streams          *sync.WaitGroup
+	auditMu          *sync.Mutex
+	streamTotals     map[string]*StreamStat
+	auditListeners   []*auditListener
+	addr             string
+	serving          chan struct{}
+	stopped          chan struct{}
+	stopErr          error
+	shuttingDown     bool
+}
+
+// Microservice is a handle to a started service
+type Microservice struct {
+	*service
+}
+
+// Addr returns the address the service listens on. It is resolved by the
+// listener, so with port 0 it has the port the OS picked
+func (ms *Microservice) Addr() string {
+	return ms.addr
+}
+
+// Ready is closed once the server is serving
+func (ms *Microservice) Ready() <-chan struct{} {
+	return ms.serving
+}
+
+// Wait blocks until the server has stopped, either after its context was
+// cancelled or because serving failed, and returns the error Serve returned
+func (ms *Microservice) Wait() error {
+	<-ms.stopped
+	return ms.stopErr
+}
+
+type logMsg struct {
+	methodName   string
+	consumerName string
+	traceID      string
+	requestID    string
+	host         string
+	at           time.Time
+	// code is the status a unary call ended with, streams are logged
+	// as they start and leave it OK
+	code codes.Code
+	// done, when set, is closed once the message was fanned out
+	done chan struct{}
+}
+
+// listener is a Logging stream in the fan-out. Nobody closes logsCh, so a
+// send to it can not panic. doneCh belongs to the stream and is closed
+// once, as it returns, a send waiting on a full logsCh gives up on it.
+// closeCh is closed once through closeWith by whoever ends the stream.
+// The fan-out sends to a copy of the listeners taken under srv.m, a
+// listener removed meanwhile may get a message more, which nobody reads
+type listener struct {
+	// dropped counts messages dropped for this listener, it goes first
+	// to stay 64-bit aligned for atomic
+	dropped   uint64
+	logsCh    chan *logMsg
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+	// closeErr is what the stream ends with once closeCh is closed
+	closeErr error
+	// shutdown is set when the server closed the stream on its way down
+	shutdown    bool
+	mode        DeliveryMode
+	sendTimeout time.Duration
+	filter      logFilter
+}
+
+// logFilter limits a Logging stream to one consumer and/or methods
+// starting with a prefix, empty fields match everything
+type logFilter struct {
+	consumer     string
+	methodPrefix string
+}
+
+func (f logFilter) match(log *logMsg) bool {
+	return (f.consumer == "" || f.consumer == log.consumerName) &&
+		strings.HasPrefix(log.methodName, f.methodPrefix)
+}
+
+func (l *listener) send(log *logMsg) error {
+	select {
+	case l.logsCh <- log:
+		return nil
+	case <-l.doneCh:
+		return errListenerClosed
+	default:
+	}
+
+	switch l.mode {
+	case BlockOnFull:
+		// a closed stream reads no more, the message is dropped
+		select {
+		case l.logsCh <- log:
+			return nil
+		case <-l.doneCh:
+			return errListenerClosed
+		case <-l.closeCh:
+		}
+
+	case CloseOnFull:
+		timer := time.NewTimer(l.sendTimeout)
+		defer timer.Stop()
+
+		select {
+		case l.logsCh <- log:
+			return nil
+		case <-l.doneCh:
+			return errListenerClosed
+		case <-l.closeCh:
+		case <-timer.C:
+			l.closeWith(status.Error(codes.DeadlineExceeded, "logging stream is too slow"))
+			return errListenerSlow
+		}
+	}
+
+	atomic.AddUint64(&l.dropped, 1)
+	return errListenerFull
+}
+
+// close signals the stream to end without waiting for it
+func (l *listener) close() {
+	l.closeWith(nil)
+}
+
+// closeForShutdown is close by a server shutting down, the stream sends
+// the shutdown sentinel before it ends
+func (l *listener) closeForShutdown() {
+	l.closeOnce.Do(func() {
+		l.shutdown = true
+		close(l.closeCh)
+	})
+}
+
+// closeWith is close with the error the stream ends with, the first
+// close wins
+func (l *listener) closeWith(err error) {
+	l.closeOnce.Do(func() {
+		l.closeErr = err
+		close(l.closeCh)
+	})
+}
+
+type statMsg struct {
+	methodName   string
+	consumerName string
+	at           time.Time
+	// handled is set for unary calls that ran the handler, which took latency
+	handled bool
+	latency time.Duration
+	// code is the status the call ended with
+	code codes.Code
+	// done, when set, is closed once the message was fanned out
+	done chan struct{}
+	// stream is set on the summary of a finished stream. It is only added to
+	// the stream totals, not counted as a call
+	stream *streamTally
+}
+
+type streamTally struct {
+	sent     uint64
+	received uint64
+	duration time.Duration
+}
+
+// statListener is listener for a Statistics stream, its channels are owned
+// the same way
+type statListener struct {
+	dropped   uint64
+	statCh    chan *statMsg
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+}
+
+func (sl *statListener) send(statMsg *statMsg) error {
+	select {
+	case sl.statCh <- statMsg:
+		return nil
+	case <-sl.doneCh:
+		return errListenerClosed
+	default:
+		atomic.AddUint64(&sl.dropped, 1)
+		return errListenerFull
+	}
+}
+
+func (sl *statListener) close() {
+	sl.closeOnce.Do(func() { close(sl.closeCh) })
+}
+
+type auditListener struct {
+	dropped   uint64
+	eventsCh  chan *AccessEvent
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+}
+
+func (al *auditListener) send(event *AccessEvent) error {
+	select {
+	case al.eventsCh <- event:
+		return nil
+	case <-al.doneCh:
+		return errListenerClosed
+	default:
+		atomic.AddUint64(&al.dropped, 1)
+		return errListenerFull
+	}
+}
+
+func (al *auditListener) close() {
+	al.closeOnce.Do(func() { close(al.closeCh) })
+}
+
+// logHistory is a fixed size ring of the most recent log messages
+type logHistory struct {
+	buf  []*logMsg
+	next int
+	full bool
+}
+
+func newLogHistory(size int) *logHistory {
+	return &logHistory{
+		buf: make([]*logMsg, size),
+	}
+}
+
+func (h *logHistory) add(log *logMsg) {
+	h.buf[h.next] = log
+	h.next = (h.next + 1) % len(h.buf)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+func (h *logHistory) reset() {
+	for i := range h.buf {
+		h.buf[i] = nil
+	}
+	h.next = 0
+	h.full = false
+}
+
+// items returns the stored messages from oldest to newest
+func (h *logHistory) items() []*logMsg {
+	if !h.full {
+		return append([]*logMsg(nil), h.buf[:h.next]...)
+	}
+
+	result := make([]*logMsg, 0, len(h.buf))
+	result = append(result, h.buf[h.next:]...)
+	return append(result, h.buf[:h.next]...)
+}
+
+// latencyBounds are the upper bounds of the latency histogram buckets,
+// anything slower goes to one more bucket after them
+var latencyBounds = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type latencyHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     time.Duration
+	max     time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{
+		buckets: make([]uint64, len(latencyBounds)+1),
+	}
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	i := sort.Search(len(latencyBounds), func(i int) bool {
+		return d <= latencyBounds[i]
+	})
+	h.buckets[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// percentile returns the upper bound of the bucket holding the p-th
+// percentile (0 < p <= 1), but never more than the slowest call seen
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	rank := uint64(math.Ceil(p * float64(h.count)))
+
+	var seen uint64
+	for i, n := range h.buckets {
+		seen += n
+		if seen < rank {
+			continue
+		}
+		if i < len(latencyBounds) && latencyBounds[i] < h.max {
+			return latencyBounds[i]
+		}
+		break
+	}
+
+	return h.max
+}
+
+const sinkBufferSize = 100
+
+type sinkWorker struct {
+	sink   EventSink
+	events chan *Event
+}
+
+// run emits events until done is closed, then flushes what is still buffered
+func (w *sinkWorker) run(done chan struct{}) {
+	for {
+		select {
+		case event := <-w.events:
+			w.sink.Emit(event)
+		case <-done:
+			for {
+				select {
+				case event := <-w.events:
+					w.sink.Emit(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// push reports false when the sink fell sinkBufferSize behind and the event
+// was dropped
+func (w *sinkWorker) push(event *Event) bool {
+	select {
+	case w.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// statSinkWorker is sinkWorker for StatSinks
+type statSinkWorker struct {
+	sink  func(*Stat)
+	stats chan *Stat
+}
+
+func (w *statSinkWorker) run(done chan struct{}) {
+	for {
+		select {
+		case stat := <-w.stats:
+			w.sink(stat)
+		case <-done:
+			for {
+				select {
+				case stat := <-w.stats:
+					w.sink(stat)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *statSinkWorker) push(stat *Stat) bool {
+	select {
+	case w.stats <- stat:
+		return true
+	default:
+		return false
+	}
+}
+
+type tempGrant struct {
+	method  string
+	expires time.Time
+}
+
+// rateLimiter keeps a token bucket per consumer
+type rateLimiter struct {
+	m         *sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		m:         &sync.Mutex{},
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from the consumer bucket, false when it is empty
+func (rl *rateLimiter) allow(consumer string, now time.Time) bool {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	rl.sweep(now)
+
+	b, ok := rl.buckets[consumer]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[consumer] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// sweep forgets buckets that refilled completely, a new bucket is the same.
+// It runs at most once per refill time, so the map stays bounded by the
+// consumers seen within it
+func (rl *rateLimiter) sweep(now time.Time) {
+	refill := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	if now.Sub(rl.lastSweep) < refill {
+		return
+	}
+	rl.lastSweep = now
+
+	for consumer, b := range rl.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(rl.buckets, consumer)
+		}
+	}
+}
+
+// authKey is what the permission cache is keyed by
+type authKey struct {
+	consumer string
+	method   string
+}
+
+// authCache remembers the allowed calls of one connection. Entries are
+// stamped with the ACL generation, a reload makes all of them stale
+type authCache struct {
+	m       *sync.Mutex
+	ttl     time.Duration
+	size    int
+	gen     uint64
+	expires map[authKey]time.Time
+}
+
+func newAuthCache(ttl time.Duration, size int) *authCache {
+	if size < 1 {
+		size = defaultAuthCacheSize
+	}
+
+	return &authCache{
+		m:       &sync.Mutex{},
+		ttl:     ttl,
+		size:    size,
+		expires: make(map[authKey]time.Time),
+	}
+}
+
+// allowed reports whether key was allowed under ACL generation gen less
+// than ttl ago
+func (c *authCache) allowed(key authKey, gen uint64, now time.Time) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.gen != gen {
+		c.gen = gen
+		c.expires = make(map[authKey]time.Time)
+		return false
+	}
+
+	expires, ok := c.expires[key]
+	if ok && now.After(expires) {
+		delete(c.expires, key)
+		return false
+	}
+
+	return ok
+}
+
+func (c *authCache) store(key authKey, gen uint64, now time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	// the check raced with a reload, its result may be of the old rules
+	if c.gen != gen {
+		return
+	}
+
+	if _, ok := c.expires[key]; !ok && len(c.expires) >= c.size {
+		for k, expires := range c.expires {
+			if now.After(expires) {
+				delete(c.expires, k)
+			}
+		}
+		// still full of live entries, start over
+		if len(c.expires) >= c.size {
+			c.expires = make(map[authKey]time.Time)
+		}
+	}
+	c.expires[key] = now.Add(c.ttl)
+}
+
+type authCacheCtxKey struct{}
+
+// connTagger counts the open connections and, with AuthCacheTTL, gives
+// every connection its own authCache, the calls of the connection find it
+// in their context
+type connTagger struct {
+	srv *service
 }
 
-type logMsg struct {
-	methodName   string
-	consumerName string
+func (t connTagger) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	if t.srv.cfg.AuthCacheTTL <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, authCacheCtxKey{}, newAuthCache(t.srv.cfg.AuthCacheTTL, t.srv.cfg.AuthCacheSize))
 }
 
-type listener struct {
-	logsCh  chan *logMsg
-	closeCh chan struct{}
+func (t connTagger) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		atomic.AddInt64(&t.srv.activeConns, 1)
+	case *stats.ConnEnd:
+		atomic.AddInt64(&t.srv.activeConns, -1)
+	}
 }
 
-type statMsg struct {
-	methodName   string
-	consumerName string
+func (t connTagger) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
 }
 
-type statListener struct {
-	statCh  chan *statMsg
-	closeCh chan struct{}
+func (t connTagger) HandleRPC(context.Context, stats.RPCStats) {}
+
+// ConnectionCount returns how many client connections are open right now
+func (srv *service) ConnectionCount() int {
+	return int(atomic.LoadInt64(&srv.activeConns))
+}
+
+// checkBizPermissionCached is checkBizPermission going through the cache
+// of the connection ctx belongs to, if there is one
+func (s *service) checkBizPermissionCached(ctx context.Context, consumer, method string) error {
+	cache, ok := ctx.Value(authCacheCtxKey{}).(*authCache)
+	if !ok {
+		return s.checkBizPermission(consumer, method)
+	}
+
+	key := authKey{consumer: consumer, method: method}
+	gen := atomic.LoadUint64(&s.aclGen)
+	now := time.Now()
+	if cache.allowed(key, gen, now) {
+		return nil
+	}
+
+	temporary, err := s.permission(consumer, method)
+	if err == nil && !temporary {
+		cache.store(key, gen, now)
+	}
+
+	return err
+}
+
+// idempotencyKeyHeader is the metadata a client marks retries of a call with
+const idempotencyKeyHeader = "idempotency-key"
+
+type idempotencyKey struct {
+	consumer string
+	method   string
+	key      string
+}
+
+// idempotentCall is the outcome of the first call with a key, done is
+// closed once it is known
+type idempotentCall struct {
+	done    chan struct{}
+	resp    interface{}
+	err     error
+	expires time.Time
+}
+
+// idempotencyStore keeps the calls made with an idempotency key for ttl
+type idempotencyStore struct {
+	m     *sync.Mutex
+	ttl   time.Duration
+	size  int
+	calls map[idempotencyKey]*idempotentCall
+}
+
+func newIdempotencyStore(ttl time.Duration, size int) *idempotencyStore {
+	if size < 1 {
+		size = defaultIdempotencySize
+	}
+
+	return &idempotencyStore{
+		m:     &sync.Mutex{},
+		ttl:   ttl,
+		size:  size,
+		calls: make(map[idempotencyKey]*idempotentCall),
+	}
+}
+
+// begin returns the call made with key, first is true when there was none
+// and the caller has to run it and report through finish
+func (st *idempotencyStore) begin(key idempotencyKey, now time.Time) (call *idempotentCall, first bool) {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	if call, ok := st.calls[key]; ok && now.Before(call.expires) {
+		return call, false
+	}
+
+	if len(st.calls) >= st.size {
+		for k, c := range st.calls {
+			if !now.Before(c.expires) {
+				delete(st.calls, k)
+			}
+		}
+		// still full, the keys kept so far are lost. Calls waiting on them
+		// hold their own pointers
+		if len(st.calls) >= st.size {
+			st.calls = make(map[idempotencyKey]*idempotentCall)
+		}
+	}
+
+	call = &idempotentCall{
+		done:    make(chan struct{}),
+		expires: now.Add(st.ttl),
+	}
+	st.calls[key] = call
+
+	return call, true
+}
+
+func (st *idempotencyStore) finish(key idempotencyKey, call *idempotentCall, resp interface{}, err error) {
+	st.m.Lock()
+	if err != nil && st.calls[key] == call {
+		delete(st.calls, key)
+	}
+	st.m.Unlock()
+
+	call.resp, call.err = resp, err
+	close(call.done)
+}
+
+// callIdempotent runs the handler, unless the call carries an idempotency
+// key that was already used, then the first call's response is returned
+func (s *service) callIdempotent(ctx context.Context,
+	consumer string,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(idempotencyKeyHeader)
+	if s.idempotency == nil || len(keys) == 0 || keys[0] == "" || !strings.HasPrefix(info.FullMethod, "/main.Biz/") {
+		return s.callUnaryHandler(ctx, req, info, handler)
+	}
+
+	key := idempotencyKey{consumer: consumer, method: info.FullMethod, key: keys[0]}
+	call, first := s.idempotency.begin(key, time.Now())
+	if first {
+		resp, err := s.callUnaryHandler(ctx, req, info, handler)
+		s.idempotency.finish(key, call, resp, err)
+		return resp, err
+	}
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			// the first call failed, this one is a retry of its own
+			return s.callIdempotent(ctx, consumer, req, info, handler)
+		}
+		return call.resp, nil
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	}
+}
+
+func StartMyMicroservice(ctx context.Context, addr, acl string, options ...Option) (*Microservice, error) {
+	srv, err := startService(ctx, addr, acl, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Microservice{srv}, nil
+}
+
+// StartMyMicroserviceFromACLFile is StartMyMicroservice with the ACL read
+// from the file at path. With ACLReloadInterval the file is reloaded when it
+// changes, a bad version is logged and the rules in use stay
+func StartMyMicroserviceFromACLFile(ctx context.Context, addr, path string, options ...Option) (*Microservice, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read the ACL file: %v", err)
+	}
+	acl, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read the ACL file: %v", err)
+	}
+
+	srv, err := startService(ctx, addr, string(acl), options...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if srv.cfg.ACLReloadInterval > 0 {
+		go srv.watchACLFile(ctx, path, info.ModTime())
+	}
+
+	return &Microservice{srv}, nil
+}
+
+// watchACLFile reloads the ACL from path whenever its modification time
+// changes, until ctx is done
+func (srv *service) watchACLFile(ctx context.Context, path string, modTime time.Time) {
+	ticker := time.NewTicker(srv.cfg.ACLReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			srv.cfg.Logger.Printf("can not check the ACL file: %v", err)
+			continue
+		}
+		if info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+
+		acl, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = srv.ReloadACL(string(acl))
+		}
+		if err != nil {
+			srv.cfg.Logger.Printf("ACL file %s not reloaded: %v", path, err)
+		}
+	}
 }
 
-func StartMyMicroservice(ctx context.Context, addr, acl string) error {
+func startService(ctx context.Context, addr, acl string, options ...Option) (*service, error) {
+	cfg := Config{}
+	for _, o := range options {
+		o(&cfg)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if cfg.ActiveWindow <= 0 {
+		cfg.ActiveWindow = defaultActiveWindow
+	}
+	if cfg.IncomingBufferSize < 0 {
+		cfg.IncomingBufferSize = 0
+	}
+	if cfg.ListenerBufferSize <= 0 {
+		cfg.ListenerBufferSize = listenerBufferSize
+	}
+	if cfg.LogSendTimeout <= 0 {
+		cfg.LogSendTimeout = defaultLogSendTimeout
+	}
+	if cfg.StatSendTimeout <= 0 {
+		cfg.StatSendTimeout = defaultStatSendTimeout
+	}
+	if cfg.Keepalive.Time <= 0 {
+		cfg.Keepalive.Time = defaultKeepaliveTime
+	}
+
 	aclParsed, err := parseACL(acl)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	network, address, err := listenAddress(addr, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("can not start the service: %v", err)
+	}
+	if cfg.Network != "" {
+		addr = address
 	}
 
-	lis, err := net.Listen("tcp", addr)
+	lis, err := listen(network, address)
 	if err != nil {
-		panic(fmt.Sprintf("can not start the service. %s", err.Error()))
+		return nil, fmt.Errorf("can not start the service: %v", err)
 	}
 
 	service := &service{
-		m:                    &sync.RWMutex{},
-		incomingLogsCh:       make(chan *logMsg, 0),
-		listeners:            make([]*listener, 0),
-		aclStorage:           aclParsed,
-		closeListenersCh:     make(chan struct{}),
-		statListeners:        make([]*statListener, 0),
-		incomingStatCh:       make(chan *statMsg, 0),
-		closeStatListenersCh: make(chan struct{}),
+		cfg:             cfg,
+		m:               &sync.RWMutex{},
+		incomingLogsCh:  make(chan *logMsg, cfg.IncomingBufferSize),
+		listeners:       make([]*listener, 0),
+		aclStorage:      aclParsed,
+		consumerGlobs:   aclConsumerGlobs(aclParsed),
+		statListeners:   make([]*statListener, 0),
+		incomingStatCh:  make(chan *statMsg, cfg.IncomingBufferSize),
+		sendersStop:     make(chan struct{}),
+		tempGrants:      make(map[string][]tempGrant),
+		activeMu:        &sync.Mutex{},
+		lastSeen:        make(map[string]time.Time),
+		openStreams:     make(map[string]int),
+		historyMu:       &sync.Mutex{},
+		sinksDone:       make(chan struct{}),
+		statSinksDone:   make(chan struct{}),
+		startedAt:       time.Now(),
+		totalByMethod:   make(map[string]uint64),
+		totalByConsumer: make(map[string]uint64),
+		latencies:       make(map[string]*latencyHistogram),
+		sinceStart:      newStatsAccumulator(cfg.MaxTrackedConsumers),
+		streams:         &sync.WaitGroup{},
+		auditMu:         &sync.Mutex{},
+		streamTotals:    make(map[string]*StreamStat),
+		addr:            lis.Addr().String(),
+		serving:         make(chan struct{}),
+		stopped:         make(chan struct{}),
+	}
+
+	for _, sink := range cfg.EventSinks {
+		if cfg.LoggingDisabled {
+			break
+		}
+		w := &sinkWorker{
+			sink:   sink,
+			events: make(chan *Event, sinkBufferSize),
+		}
+		service.sinks = append(service.sinks, w)
+		go w.run(service.sinksDone)
+	}
+
+	for _, sink := range cfg.StatSinks {
+		if cfg.StatsDisabled {
+			break
+		}
+		w := &statSinkWorker{
+			sink:  sink,
+			stats: make(chan *Stat, sinkBufferSize),
+		}
+		service.statSinks = append(service.statSinks, w)
+		go w.run(service.statSinksDone)
+	}
+
+	if cfg.Metrics {
+		service.callCounts = make(map[callKey]uint64)
+	}
+
+	if cfg.RateLimit > 0 {
+		service.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+	if cfg.IdempotencyTTL > 0 {
+		service.idempotency = newIdempotencyStore(cfg.IdempotencyTTL, cfg.IdempotencySize)
 	}
 
-	go service.logsSender()
-	go service.statsSender()
+	if cfg.LogHistorySize > 0 {
+		service.history = newLogHistory(cfg.LogHistorySize)
+	}
+
+	if !cfg.LoggingDisabled {
+		go service.logsSender()
+	}
+	if !cfg.StatsDisabled {
+		go service.statsSender()
+	}
 
-	opts := []grpc.ServerOption{grpc.UnaryInterceptor(service.unaryInterceptor),
-		grpc.StreamInterceptor(service.streamInterceptor)}
+	unary := append(append([]grpc.UnaryServerInterceptor{}, cfg.UnaryInterceptors...), service.unaryInterceptor)
+	stream := append(append([]grpc.StreamServerInterceptor{}, cfg.StreamInterceptors...), service.streamInterceptor)
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(chainUnaryInterceptors(unary)),
+		grpc.StreamInterceptor(chainStreamInterceptors(stream)),
+		grpc.KeepaliveParams(cfg.Keepalive),
+		grpc.KeepaliveEnforcementPolicy(cfg.KeepaliveEnforcement)}
+	if cfg.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(cfg.MaxSendMsgSize))
+	}
+	opts = append(opts, grpc.StatsHandler(connTagger{srv: service}))
 
 	srv := grpc.NewServer(opts...)
-	fmt.Println("starting server at: ", addr)
+	cfg.Logger.Println("starting server at:", addr)
 
 	RegisterBizServer(srv, service)
 	RegisterAdminServer(srv, service)
+	if cfg.Reflection {
+		reflection.Register(srv)
+	}
+
+	serveErr := make(chan error, 1)
 
 	go func() {
+		var err error
+
 		select {
 		case <-ctx.Done():
-			service.closeListenersCh <- struct{}{}
+			service.shutdown(srv)
+			err = <-serveErr
+
+		case err = <-serveErr:
+			// the streams and connections Serve had are still up
+			service.shutdown(srv)
+		}
 
-			service.closeStatListenersCh <- struct{}{}
+		// senders outlive GracefulStop, so in-flight calls can still report
+		close(service.sendersStop)
 
-			srv.Stop()
-			return
+		if cfg.OnStopped != nil {
+			cfg.OnStopped(err)
 		}
+		if network == "unix" {
+			if rmErr := os.Remove(address); rmErr != nil && !os.IsNotExist(rmErr) {
+				cfg.Logger.Println("can not remove the socket:", rmErr)
+			}
+		}
+
+		service.stopErr = err
+		close(service.stopped)
 	}()
 
 	go func() {
-		err := srv.Serve(lis)
-		if err != nil {
-			panic(err)
+		if cfg.OnServing != nil {
+			cfg.OnServing()
 		}
-		return
+		close(service.serving)
+
+		serveErr <- srv.Serve(lis)
+	}()
+
+	return service, nil
+}
+
+// shutdown refuses new admin streams, closes the ones open and stops srv
+func (s *service) shutdown(srv *grpc.Server) {
+	s.m.Lock()
+	s.shuttingDown = true
+	s.m.Unlock()
+
+	// admin streams are closed first, so they end cleanly. It is not left
+	// to the senders, one may be stuck on a stream that does not read
+	s.closeLogListeners()
+	s.closeStatListeners()
+
+	s.waitStreams()
+	s.stopServer(srv)
+}
+
+// waitStreams waits up to ShutdownTimeout for the admin streams to return
+// after their listeners were closed
+func (s *service) waitStreams() {
+	drained := make(chan struct{})
+	go func() {
+		s.streams.Wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(s.cfg.ShutdownTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+	case <-timer.C:
+		s.cfg.Logger.Println("admin streams did not drain in time")
+	}
+}
+
+// unixScheme in front of the address makes the service listen on a unix
+// socket at the path after it, a plain host:port is tcp
+const unixScheme = "unix://"
+
+// listenAddress picks the network and address to listen on: the ones set
+// WithNetwork, otherwise addr with unixScheme or tcp
+func listenAddress(addr string, cfg Config) (network, address string, err error) {
+	if cfg.Network == "" {
+		if path := strings.TrimPrefix(addr, unixScheme); path != addr {
+			return "unix", path, nil
+		}
+		return "tcp", addr, nil
+	}
+
+	switch cfg.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return cfg.Network, cfg.Address, nil
+	}
+
+	return "", "", fmt.Errorf("unsupported network %q, want tcp, tcp4, tcp6 or unix", cfg.Network)
+}
+
+func listen(network, address string) (net.Listener, error) {
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	// a socket left by a process that did not stop cleanly, other files
+	// are not ours to remove
+	if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(address); err != nil {
+			return nil, err
+		}
+	}
+
+	return net.Listen("unix", address)
+}
+
+// stopServer lets in-flight calls finish, falling back to a hard stop
+// after ShutdownTimeout
+func (s *service) stopServer(srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
 	}()
 
-	return nil
+	timer := time.NewTimer(s.cfg.ShutdownTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+	case <-timer.C:
+		srv.Stop()
+		<-stopped
+	}
+}
+
+// chainUnaryInterceptors makes one interceptor of several, the first is
+// the outermost. grpc takes a single one
+func chainUnaryInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// chainStreamInterceptors is chainUnaryInterceptors for streams
+func chainStreamInterceptors(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, inner)
+			}
+		}
+		return next(srv, ss)
+	}
 }
 
 func (s *service) unaryInterceptor(ctx context.Context,
 	req interface{},
 	info *grpc.UnaryServerInfo,
 	handler grpc.UnaryHandler) (interface{}, error) {
-	consumer, err := getConsumerNameFromContext(ctx)
+	// probes come without a consumer and are not counted
+	if info.FullMethod == healthMethod {
+		return handler(ctx, req)
+	}
+
+	start := time.Now()
+
+	// every response carries the id, rejected calls included. Outside of
+	// a real call there is no stream to set it on, which is fine
+	requestID := getRequestIDFromContext(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, requestID))
+
+	// resolved once, the audit record names the consumer the ACL checked
+	var h interface{}
+	consumer, err := s.getConsumerNameFromContext(ctx)
+	if err == nil {
+		h, err = s.interceptUnary(ctx, consumer, requestID, req, info, handler)
+	}
+	s.writeAudit(ctx, consumer, info.FullMethod, start, err)
+
+	return h, err
+}
+
+func (s *service) interceptUnary(ctx context.Context,
+	consumer, requestID string,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	statMsg := statMsg{
+		consumerName: consumer,
+		methodName:   info.FullMethod,
+		at:           time.Now(),
+	}
+
+	err := s.checkBizPermissionCached(ctx, consumer, info.FullMethod)
+	s.auditAccess(consumer, info.FullMethod, err)
 	if err != nil {
+		if s.cfg.CountDeniedCalls {
+			statMsg.code = grpc.Code(err)
+			s.sendStat(&statMsg)
+		}
 		return nil, err
 	}
 
-	err = s.checkBizPermission(consumer, info.FullMethod)
-	if err != nil {
+	if strings.HasPrefix(info.FullMethod, "/main.Admin/") && s.isAdminStopped() {
+		return nil, grpc.Errorf(codes.Unavailable, "admin is stopped")
+	}
+
+	if strings.HasPrefix(info.FullMethod, "/main.Biz/") && s.isDraining() {
+		return nil, grpc.Errorf(codes.Unavailable, "server is draining")
+	}
+
+	// a call given up on before it got here is counted, but not run or logged
+	if err := contextError(ctx); err != nil {
+		s.cfg.Logger.Printf("%s by %s dropped before the handler: %v", info.FullMethod, consumer, ctx.Err())
+		statMsg.code = grpc.Code(err)
+		s.sendStat(&statMsg)
 		return nil, err
 	}
 
 	logMsg := logMsg{
 		consumerName: consumer,
 		methodName:   info.FullMethod,
+		traceID:      getTraceIDFromContext(ctx),
+		requestID:    requestID,
+		host:         s.getHostFromContext(ctx),
+		at:           statMsg.at,
 	}
 
-	s.incomingLogsCh <- &logMsg
+	// limited calls are logged and counted, but never reach the handler
+	if s.limiter != nil && !s.limiter.allow(consumer, statMsg.at) {
+		err = status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		statMsg.code = codes.ResourceExhausted
+		logMsg.code = codes.ResourceExhausted
+		s.sendLog(&logMsg)
+		s.sendStat(&statMsg)
+		return nil, err
+	}
 
-	statMsg := statMsg{
-		consumerName: consumer,
-		methodName:   info.FullMethod,
+	start := time.Now()
+	handlerCtx := withRequestID(s.handlerContext(ctx, consumer), requestID)
+	if s.cfg.HandlerTimeout > 0 && strings.HasPrefix(info.FullMethod, "/main.Biz/") {
+		var cancel context.CancelFunc
+		handlerCtx, cancel = context.WithTimeout(handlerCtx, s.cfg.HandlerTimeout)
+		defer cancel()
 	}
+	h, err := s.callIdempotent(handlerCtx, consumer, req, info, handler)
+	statMsg.latency = time.Since(start)
+	statMsg.handled = true
+	statMsg.code = grpc.Code(err)
 
-	s.incomingStatCh <- &statMsg
+	// logged once done, so the event tells how the call went
+	logMsg.code = statMsg.code
+	s.sendLog(&logMsg)
+	s.sendStat(&statMsg)
 
-	h, err := handler(ctx, req)
 	return h, err
 }
 
+// sendLog hands logMsg to logsSender and waits for done when it is set.
+// Once the senders stopped the message is dropped
+func (s *service) sendLog(logMsg *logMsg) {
+	if s.cfg.LoggingDisabled {
+		return
+	}
+
+	select {
+	case s.incomingLogsCh <- logMsg:
+	case <-s.sendersStop:
+		return
+	}
+	if logMsg.done != nil {
+		select {
+		case <-logMsg.done:
+		case <-s.sendersStop:
+		}
+	}
+}
+
+// sendStat is sendLog for statsSender
+func (s *service) sendStat(statMsg *statMsg) {
+	if s.cfg.StatsDisabled {
+		return
+	}
+
+	select {
+	case s.incomingStatCh <- statMsg:
+	case <-s.sendersStop:
+		return
+	}
+	if statMsg.done != nil {
+		select {
+		case <-statMsg.done:
+		case <-s.sendersStop:
+		}
+	}
+}
+
 func (s *service) streamInterceptor(srv interface{},
 	ss grpc.ServerStream,
 	info *grpc.StreamServerInfo,
 	handler grpc.StreamHandler) error {
-	consumer, err := getConsumerNameFromContext(ss.Context())
-	if err != nil {
-		return err
+	if strings.HasPrefix(info.FullMethod, reflectionService) {
+		return handler(srv, ss)
 	}
 
-	err = s.checkBizPermission(consumer, info.FullMethod)
+	start := time.Now()
+	consumer, err := s.getConsumerNameFromContext(ss.Context())
+	if err == nil {
+		err = s.interceptStream(srv, ss, consumer, info, handler)
+	}
+	s.writeAudit(ss.Context(), consumer, info.FullMethod, start, err)
+
+	return err
+}
+
+func (s *service) interceptStream(srv interface{},
+	ss grpc.ServerStream,
+	consumer string,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	err := s.checkBizPermission(consumer, info.FullMethod)
+	s.auditAccess(consumer, info.FullMethod, err)
 	if err != nil {
 		return err
 	}
 
+	if strings.HasPrefix(info.FullMethod, "/main.Admin/") && s.isAdminStopped() {
+		return grpc.Errorf(codes.Unavailable, "admin is stopped")
+	}
+
+	// the call is fanned out before its own stream subscribes,
+	// so a stream never gets itself
 	if info.FullMethod == "/main.Admin/Logging" {
 		msg := logMsg{
 			consumerName: consumer,
 			methodName:   info.FullMethod,
+			traceID:      getTraceIDFromContext(ss.Context()),
+			host:         s.getHostFromContext(ss.Context()),
+			at:           time.Now(),
+			done:         make(chan struct{}),
 		}
-		s.m.RLock()
-		for _, l := range s.listeners {
-			l.logsCh <- &msg
-		}
-		s.m.RUnlock()
+		s.sendLog(&msg)
 
 	} else {
 		msg := statMsg{
 			consumerName: consumer,
 			methodName:   info.FullMethod,
+			at:           time.Now(),
+			done:         make(chan struct{}),
 		}
+		s.sendStat(&msg)
+	}
 
-		s.m.RLock()
-		for _, l := range s.statListeners {
-			l.statCh <- &msg
-		}
-		s.m.RUnlock()
+	s.openStream(consumer)
+	defer s.closeStream(consumer)
 
+	cs := &countingServerStream{
+		ServerStream: &serverStreamWithContext{
+			ServerStream: ss,
+			ctx:          s.handlerContext(ss.Context(), consumer),
+		},
 	}
 
+	start := time.Now()
+	err = s.callStreamHandler(srv, cs, info, handler)
+
+	// the final tally only goes into the stream totals
+	s.sendStat(&statMsg{
+		methodName: info.FullMethod,
+		stream: &streamTally{
+			sent:     atomic.LoadUint64(&cs.sent),
+			received: atomic.LoadUint64(&cs.received),
+			duration: time.Since(start),
+		},
+	})
+
+	return err
+}
+
+// callUnaryHandler runs handler, a panic in it becomes an Internal error
+func (s *service) callUnaryHandler(ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.cfg.Logger.Printf("panic in %s: %v", info.FullMethod, r)
+			resp, err = nil, status.Error(codes.Internal, "internal error")
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+func (s *service) callStreamHandler(srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.cfg.Logger.Printf("panic in %s: %v", info.FullMethod, r)
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+
 	return handler(srv, ss)
 }
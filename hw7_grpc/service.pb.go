@@ -23,11 +23,40 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type HealthStatus_Status int32
+
+const (
+	HealthStatus_UNKNOWN     HealthStatus_Status = 0
+	HealthStatus_SERVING     HealthStatus_Status = 1
+	HealthStatus_NOT_SERVING HealthStatus_Status = 2
+)
+
+var HealthStatus_Status_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+}
+var HealthStatus_Status_value = map[string]int32{
+	"UNKNOWN":     0,
+	"SERVING":     1,
+	"NOT_SERVING": 2,
+}
+
+func (x HealthStatus_Status) String() string {
+	return proto.EnumName(HealthStatus_Status_name, int32(x))
+}
+func (HealthStatus_Status) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_service_0f8bf1293be38936, []int{5, 0}
+}
+
 type Event struct {
 	Timestamp            int64    `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	Consumer             string   `protobuf:"bytes,2,opt,name=consumer,proto3" json:"consumer,omitempty"`
 	Method               string   `protobuf:"bytes,3,opt,name=method,proto3" json:"method,omitempty"`
 	Host                 string   `protobuf:"bytes,4,opt,name=host,proto3" json:"host,omitempty"`
+	TraceId              string   `protobuf:"bytes,5,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	RequestId            string   `protobuf:"bytes,6,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Code                 int32    `protobuf:"varint,7,opt,name=code,proto3" json:"code,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -37,7 +66,7 @@ func (m *Event) Reset()         { *m = Event{} }
 func (m *Event) String() string { return proto.CompactTextString(m) }
 func (*Event) ProtoMessage()    {}
 func (*Event) Descriptor() ([]byte, []int) {
-	return fileDescriptor_service_8108dcf1dd6080ef, []int{0}
+	return fileDescriptor_service_0f8bf1293be38936, []int{0}
 }
 func (m *Event) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Event.Unmarshal(m, b)
@@ -85,10 +114,146 @@ func (m *Event) GetHost() string {
 	return ""
 }
 
+func (m *Event) GetTraceId() string {
+	if m != nil {
+		return m.TraceId
+	}
+	return ""
+}
+
+func (m *Event) GetRequestId() string {
+	if m != nil {
+		return m.RequestId
+	}
+	return ""
+}
+
+func (m *Event) GetCode() int32 {
+	if m != nil {
+		return m.Code
+	}
+	return 0
+}
+
+type AccessEvent struct {
+	Timestamp            int64    `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Consumer             string   `protobuf:"bytes,2,opt,name=consumer,proto3" json:"consumer,omitempty"`
+	Method               string   `protobuf:"bytes,3,opt,name=method,proto3" json:"method,omitempty"`
+	Decision             string   `protobuf:"bytes,4,opt,name=decision,proto3" json:"decision,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AccessEvent) Reset()         { *m = AccessEvent{} }
+func (m *AccessEvent) String() string { return proto.CompactTextString(m) }
+func (*AccessEvent) ProtoMessage()    {}
+func (*AccessEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_service_0f8bf1293be38936, []int{1}
+}
+func (m *AccessEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AccessEvent.Unmarshal(m, b)
+}
+func (m *AccessEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AccessEvent.Marshal(b, m, deterministic)
+}
+func (dst *AccessEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AccessEvent.Merge(dst, src)
+}
+func (m *AccessEvent) XXX_Size() int {
+	return xxx_messageInfo_AccessEvent.Size(m)
+}
+func (m *AccessEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_AccessEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AccessEvent proto.InternalMessageInfo
+
+func (m *AccessEvent) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *AccessEvent) GetConsumer() string {
+	if m != nil {
+		return m.Consumer
+	}
+	return ""
+}
+
+func (m *AccessEvent) GetMethod() string {
+	if m != nil {
+		return m.Method
+	}
+	return ""
+}
+
+func (m *AccessEvent) GetDecision() string {
+	if m != nil {
+		return m.Decision
+	}
+	return ""
+}
+
+type AccessDenied struct {
+	Consumer             string   `protobuf:"bytes,1,opt,name=consumer,proto3" json:"consumer,omitempty"`
+	Method               string   `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AccessDenied) Reset()         { *m = AccessDenied{} }
+func (m *AccessDenied) String() string { return proto.CompactTextString(m) }
+func (*AccessDenied) ProtoMessage()    {}
+func (*AccessDenied) Descriptor() ([]byte, []int) {
+	return fileDescriptor_service_0f8bf1293be38936, []int{2}
+}
+func (m *AccessDenied) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AccessDenied.Unmarshal(m, b)
+}
+func (m *AccessDenied) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AccessDenied.Marshal(b, m, deterministic)
+}
+func (dst *AccessDenied) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AccessDenied.Merge(dst, src)
+}
+func (m *AccessDenied) XXX_Size() int {
+	return xxx_messageInfo_AccessDenied.Size(m)
+}
+func (m *AccessDenied) XXX_DiscardUnknown() {
+	xxx_messageInfo_AccessDenied.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AccessDenied proto.InternalMessageInfo
+
+func (m *AccessDenied) GetConsumer() string {
+	if m != nil {
+		return m.Consumer
+	}
+	return ""
+}
+
+func (m *AccessDenied) GetMethod() string {
+	if m != nil {
+		return m.Method
+	}
+	return ""
+}
+
 type Stat struct {
 	Timestamp            int64             `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	ByMethod             map[string]uint64 `protobuf:"bytes,2,rep,name=by_method,json=byMethod,proto3" json:"by_method,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
 	ByConsumer           map[string]uint64 `protobuf:"bytes,3,rep,name=by_consumer,json=byConsumer,proto3" json:"by_consumer,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	ErrorsByMethod       map[string]uint64 `protobuf:"bytes,4,rep,name=errors_by_method,json=errorsByMethod,proto3" json:"errors_by_method,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	ErrorsByConsumer     map[string]uint64 `protobuf:"bytes,5,rep,name=errors_by_consumer,json=errorsByConsumer,proto3" json:"errors_by_consumer,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	Dropped              uint64            `protobuf:"varint,6,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	LifetimeByConsumer   map[string]uint64 `protobuf:"bytes,7,rep,name=lifetime_by_consumer,json=lifetimeByConsumer,proto3" json:"lifetime_by_consumer,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	ActiveLoggingStreams uint64            `protobuf:"varint,8,opt,name=active_logging_streams,json=activeLoggingStreams,proto3" json:"active_logging_streams,omitempty"`
+	ActiveStatStreams    uint64            `protobuf:"varint,9,opt,name=active_stat_streams,json=activeStatStreams,proto3" json:"active_stat_streams,omitempty"`
+	ActiveConnections    uint64            `protobuf:"varint,10,opt,name=active_connections,json=activeConnections,proto3" json:"active_connections,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
 	XXX_unrecognized     []byte            `json:"-"`
 	XXX_sizecache        int32             `json:"-"`
@@ -98,7 +263,7 @@ func (m *Stat) Reset()         { *m = Stat{} }
 func (m *Stat) String() string { return proto.CompactTextString(m) }
 func (*Stat) ProtoMessage()    {}
 func (*Stat) Descriptor() ([]byte, []int) {
-	return fileDescriptor_service_8108dcf1dd6080ef, []int{1}
+	return fileDescriptor_service_0f8bf1293be38936, []int{3}
 }
 func (m *Stat) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Stat.Unmarshal(m, b)
@@ -139,8 +304,61 @@ func (m *Stat) GetByConsumer() map[string]uint64 {
 	return nil
 }
 
+func (m *Stat) GetErrorsByMethod() map[string]uint64 {
+	if m != nil {
+		return m.ErrorsByMethod
+	}
+	return nil
+}
+
+func (m *Stat) GetErrorsByConsumer() map[string]uint64 {
+	if m != nil {
+		return m.ErrorsByConsumer
+	}
+	return nil
+}
+
+func (m *Stat) GetDropped() uint64 {
+	if m != nil {
+		return m.Dropped
+	}
+	return 0
+}
+
+func (m *Stat) GetLifetimeByConsumer() map[string]uint64 {
+	if m != nil {
+		return m.LifetimeByConsumer
+	}
+	return nil
+}
+
+func (m *Stat) GetActiveLoggingStreams() uint64 {
+	if m != nil {
+		return m.ActiveLoggingStreams
+	}
+	return 0
+}
+
+func (m *Stat) GetActiveStatStreams() uint64 {
+	if m != nil {
+		return m.ActiveStatStreams
+	}
+	return 0
+}
+
+func (m *Stat) GetActiveConnections() uint64 {
+	if m != nil {
+		return m.ActiveConnections
+	}
+	return 0
+}
+
 type StatInterval struct {
 	IntervalSeconds      uint64   `protobuf:"varint,1,opt,name=interval_seconds,json=intervalSeconds,proto3" json:"interval_seconds,omitempty"`
+	IntervalMs           uint64   `protobuf:"varint,2,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	SendInitial          bool     `protobuf:"varint,3,opt,name=send_initial,json=sendInitial,proto3" json:"send_initial,omitempty"`
+	Consumer             string   `protobuf:"bytes,4,opt,name=consumer,proto3" json:"consumer,omitempty"`
+	Method               string   `protobuf:"bytes,5,opt,name=method,proto3" json:"method,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -150,7 +368,7 @@ func (m *StatInterval) Reset()         { *m = StatInterval{} }
 func (m *StatInterval) String() string { return proto.CompactTextString(m) }
 func (*StatInterval) ProtoMessage()    {}
 func (*StatInterval) Descriptor() ([]byte, []int) {
-	return fileDescriptor_service_8108dcf1dd6080ef, []int{2}
+	return fileDescriptor_service_0f8bf1293be38936, []int{4}
 }
 func (m *StatInterval) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_StatInterval.Unmarshal(m, b)
@@ -177,8 +395,198 @@ func (m *StatInterval) GetIntervalSeconds() uint64 {
 	return 0
 }
 
+func (m *StatInterval) GetIntervalMs() uint64 {
+	if m != nil {
+		return m.IntervalMs
+	}
+	return 0
+}
+
+func (m *StatInterval) GetSendInitial() bool {
+	if m != nil {
+		return m.SendInitial
+	}
+	return false
+}
+
+func (m *StatInterval) GetConsumer() string {
+	if m != nil {
+		return m.Consumer
+	}
+	return ""
+}
+
+func (m *StatInterval) GetMethod() string {
+	if m != nil {
+		return m.Method
+	}
+	return ""
+}
+
+type HealthStatus struct {
+	Status               HealthStatus_Status `protobuf:"varint,1,opt,name=status,proto3,enum=main.HealthStatus_Status" json:"status,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
+}
+
+func (m *HealthStatus) Reset()         { *m = HealthStatus{} }
+func (m *HealthStatus) String() string { return proto.CompactTextString(m) }
+func (*HealthStatus) ProtoMessage()    {}
+func (*HealthStatus) Descriptor() ([]byte, []int) {
+	return fileDescriptor_service_0f8bf1293be38936, []int{5}
+}
+func (m *HealthStatus) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HealthStatus.Unmarshal(m, b)
+}
+func (m *HealthStatus) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HealthStatus.Marshal(b, m, deterministic)
+}
+func (dst *HealthStatus) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HealthStatus.Merge(dst, src)
+}
+func (m *HealthStatus) XXX_Size() int {
+	return xxx_messageInfo_HealthStatus.Size(m)
+}
+func (m *HealthStatus) XXX_DiscardUnknown() {
+	xxx_messageInfo_HealthStatus.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HealthStatus proto.InternalMessageInfo
+
+func (m *HealthStatus) GetStatus() HealthStatus_Status {
+	if m != nil {
+		return m.Status
+	}
+	return HealthStatus_UNKNOWN
+}
+
+type ConsumerList struct {
+	Consumers            []string `protobuf:"bytes,1,rep,name=consumers,proto3" json:"consumers,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ConsumerList) Reset()         { *m = ConsumerList{} }
+func (m *ConsumerList) String() string { return proto.CompactTextString(m) }
+func (*ConsumerList) ProtoMessage()    {}
+func (*ConsumerList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_service_0f8bf1293be38936, []int{6}
+}
+func (m *ConsumerList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ConsumerList.Unmarshal(m, b)
+}
+func (m *ConsumerList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ConsumerList.Marshal(b, m, deterministic)
+}
+func (dst *ConsumerList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ConsumerList.Merge(dst, src)
+}
+func (m *ConsumerList) XXX_Size() int {
+	return xxx_messageInfo_ConsumerList.Size(m)
+}
+func (m *ConsumerList) XXX_DiscardUnknown() {
+	xxx_messageInfo_ConsumerList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ConsumerList proto.InternalMessageInfo
+
+func (m *ConsumerList) GetConsumers() []string {
+	if m != nil {
+		return m.Consumers
+	}
+	return nil
+}
+
+type ConsumerName struct {
+	Consumer             string   `protobuf:"bytes,1,opt,name=consumer,proto3" json:"consumer,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ConsumerName) Reset()         { *m = ConsumerName{} }
+func (m *ConsumerName) String() string { return proto.CompactTextString(m) }
+func (*ConsumerName) ProtoMessage()    {}
+func (*ConsumerName) Descriptor() ([]byte, []int) {
+	return fileDescriptor_service_0f8bf1293be38936, []int{7}
+}
+func (m *ConsumerName) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ConsumerName.Unmarshal(m, b)
+}
+func (m *ConsumerName) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ConsumerName.Marshal(b, m, deterministic)
+}
+func (dst *ConsumerName) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ConsumerName.Merge(dst, src)
+}
+func (m *ConsumerName) XXX_Size() int {
+	return xxx_messageInfo_ConsumerName.Size(m)
+}
+func (m *ConsumerName) XXX_DiscardUnknown() {
+	xxx_messageInfo_ConsumerName.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ConsumerName proto.InternalMessageInfo
+
+func (m *ConsumerName) GetConsumer() string {
+	if m != nil {
+		return m.Consumer
+	}
+	return ""
+}
+
+type ACLEntries struct {
+	Rules                []string `protobuf:"bytes,1,rep,name=rules,proto3" json:"rules,omitempty"`
+	Methods              []string `protobuf:"bytes,2,rep,name=methods,proto3" json:"methods,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ACLEntries) Reset()         { *m = ACLEntries{} }
+func (m *ACLEntries) String() string { return proto.CompactTextString(m) }
+func (*ACLEntries) ProtoMessage()    {}
+func (*ACLEntries) Descriptor() ([]byte, []int) {
+	return fileDescriptor_service_0f8bf1293be38936, []int{8}
+}
+func (m *ACLEntries) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ACLEntries.Unmarshal(m, b)
+}
+func (m *ACLEntries) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ACLEntries.Marshal(b, m, deterministic)
+}
+func (dst *ACLEntries) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ACLEntries.Merge(dst, src)
+}
+func (m *ACLEntries) XXX_Size() int {
+	return xxx_messageInfo_ACLEntries.Size(m)
+}
+func (m *ACLEntries) XXX_DiscardUnknown() {
+	xxx_messageInfo_ACLEntries.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ACLEntries proto.InternalMessageInfo
+
+func (m *ACLEntries) GetRules() []string {
+	if m != nil {
+		return m.Rules
+	}
+	return nil
+}
+
+func (m *ACLEntries) GetMethods() []string {
+	if m != nil {
+		return m.Methods
+	}
+	return nil
+}
+
 type Nothing struct {
 	Dummy                bool     `protobuf:"varint,1,opt,name=dummy,proto3" json:"dummy,omitempty"`
+	Consumer             string   `protobuf:"bytes,2,opt,name=consumer,proto3" json:"consumer,omitempty"`
+	MethodPrefix         string   `protobuf:"bytes,3,opt,name=method_prefix,json=methodPrefix,proto3" json:"method_prefix,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -188,7 +596,7 @@ func (m *Nothing) Reset()         { *m = Nothing{} }
 func (m *Nothing) String() string { return proto.CompactTextString(m) }
 func (*Nothing) ProtoMessage()    {}
 func (*Nothing) Descriptor() ([]byte, []int) {
-	return fileDescriptor_service_8108dcf1dd6080ef, []int{3}
+	return fileDescriptor_service_0f8bf1293be38936, []int{9}
 }
 func (m *Nothing) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Nothing.Unmarshal(m, b)
@@ -215,13 +623,37 @@ func (m *Nothing) GetDummy() bool {
 	return false
 }
 
+func (m *Nothing) GetConsumer() string {
+	if m != nil {
+		return m.Consumer
+	}
+	return ""
+}
+
+func (m *Nothing) GetMethodPrefix() string {
+	if m != nil {
+		return m.MethodPrefix
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Event)(nil), "main.Event")
+	proto.RegisterType((*AccessEvent)(nil), "main.AccessEvent")
+	proto.RegisterType((*AccessDenied)(nil), "main.AccessDenied")
 	proto.RegisterType((*Stat)(nil), "main.Stat")
 	proto.RegisterMapType((map[string]uint64)(nil), "main.Stat.ByConsumerEntry")
 	proto.RegisterMapType((map[string]uint64)(nil), "main.Stat.ByMethodEntry")
+	proto.RegisterMapType((map[string]uint64)(nil), "main.Stat.ErrorsByConsumerEntry")
+	proto.RegisterMapType((map[string]uint64)(nil), "main.Stat.ErrorsByMethodEntry")
+	proto.RegisterMapType((map[string]uint64)(nil), "main.Stat.LifetimeByConsumerEntry")
 	proto.RegisterType((*StatInterval)(nil), "main.StatInterval")
+	proto.RegisterType((*HealthStatus)(nil), "main.HealthStatus")
+	proto.RegisterType((*ConsumerList)(nil), "main.ConsumerList")
+	proto.RegisterType((*ConsumerName)(nil), "main.ConsumerName")
+	proto.RegisterType((*ACLEntries)(nil), "main.ACLEntries")
 	proto.RegisterType((*Nothing)(nil), "main.Nothing")
+	proto.RegisterEnum("main.HealthStatus_Status", HealthStatus_Status_name, HealthStatus_Status_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -238,6 +670,13 @@ const _ = grpc.SupportPackageIsVersion4
 type AdminClient interface {
 	Logging(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (Admin_LoggingClient, error)
 	Statistics(ctx context.Context, in *StatInterval, opts ...grpc.CallOption) (Admin_StatisticsClient, error)
+	StatisticsInteractive(ctx context.Context, opts ...grpc.CallOption) (Admin_StatisticsInteractiveClient, error)
+	DrainEvents(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (Admin_DrainEventsClient, error)
+	AuditAccess(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (Admin_AuditAccessClient, error)
+	Health(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*HealthStatus, error)
+	Snapshot(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*Stat, error)
+	ListConsumers(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*ConsumerList, error)
+	EffectiveACL(ctx context.Context, in *ConsumerName, opts ...grpc.CallOption) (*ACLEntries, error)
 }
 
 type adminClient struct {
@@ -312,10 +751,148 @@ func (x *adminStatisticsClient) Recv() (*Stat, error) {
 	return m, nil
 }
 
+func (c *adminClient) StatisticsInteractive(ctx context.Context, opts ...grpc.CallOption) (Admin_StatisticsInteractiveClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Admin_serviceDesc.Streams[2], "/main.Admin/StatisticsInteractive", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminStatisticsInteractiveClient{stream}
+	return x, nil
+}
+
+type Admin_StatisticsInteractiveClient interface {
+	Send(*StatInterval) error
+	Recv() (*Stat, error)
+	grpc.ClientStream
+}
+
+type adminStatisticsInteractiveClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminStatisticsInteractiveClient) Send(m *StatInterval) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *adminStatisticsInteractiveClient) Recv() (*Stat, error) {
+	m := new(Stat)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) DrainEvents(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (Admin_DrainEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Admin_serviceDesc.Streams[3], "/main.Admin/DrainEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminDrainEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Admin_DrainEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type adminDrainEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminDrainEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) AuditAccess(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (Admin_AuditAccessClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Admin_serviceDesc.Streams[4], "/main.Admin/AuditAccess", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminAuditAccessClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Admin_AuditAccessClient interface {
+	Recv() (*AccessEvent, error)
+	grpc.ClientStream
+}
+
+type adminAuditAccessClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminAuditAccessClient) Recv() (*AccessEvent, error) {
+	m := new(AccessEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) Health(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*HealthStatus, error) {
+	out := new(HealthStatus)
+	err := c.cc.Invoke(ctx, "/main.Admin/Health", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Snapshot(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*Stat, error) {
+	out := new(Stat)
+	err := c.cc.Invoke(ctx, "/main.Admin/Snapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListConsumers(ctx context.Context, in *Nothing, opts ...grpc.CallOption) (*ConsumerList, error) {
+	out := new(ConsumerList)
+	err := c.cc.Invoke(ctx, "/main.Admin/ListConsumers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) EffectiveACL(ctx context.Context, in *ConsumerName, opts ...grpc.CallOption) (*ACLEntries, error) {
+	out := new(ACLEntries)
+	err := c.cc.Invoke(ctx, "/main.Admin/EffectiveACL", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // AdminServer is the server API for Admin service.
 type AdminServer interface {
 	Logging(*Nothing, Admin_LoggingServer) error
 	Statistics(*StatInterval, Admin_StatisticsServer) error
+	StatisticsInteractive(Admin_StatisticsInteractiveServer) error
+	DrainEvents(*Nothing, Admin_DrainEventsServer) error
+	AuditAccess(*Nothing, Admin_AuditAccessServer) error
+	Health(context.Context, *Nothing) (*HealthStatus, error)
+	Snapshot(context.Context, *Nothing) (*Stat, error)
+	ListConsumers(context.Context, *Nothing) (*ConsumerList, error)
+	EffectiveACL(context.Context, *ConsumerName) (*ACLEntries, error)
 }
 
 func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
@@ -364,10 +941,167 @@ func (x *adminStatisticsServer) Send(m *Stat) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Admin_StatisticsInteractive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AdminServer).StatisticsInteractive(&adminStatisticsInteractiveServer{stream})
+}
+
+type Admin_StatisticsInteractiveServer interface {
+	Send(*Stat) error
+	Recv() (*StatInterval, error)
+	grpc.ServerStream
+}
+
+type adminStatisticsInteractiveServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminStatisticsInteractiveServer) Send(m *Stat) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *adminStatisticsInteractiveServer) Recv() (*StatInterval, error) {
+	m := new(StatInterval)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Admin_DrainEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Nothing)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).DrainEvents(m, &adminDrainEventsServer{stream})
+}
+
+type Admin_DrainEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type adminDrainEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminDrainEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Admin_AuditAccess_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Nothing)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).AuditAccess(m, &adminAuditAccessServer{stream})
+}
+
+type Admin_AuditAccessServer interface {
+	Send(*AccessEvent) error
+	grpc.ServerStream
+}
+
+type adminAuditAccessServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminAuditAccessServer) Send(m *AccessEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Admin_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Nothing)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/main.Admin/Health",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Health(ctx, req.(*Nothing))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Snapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Nothing)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Snapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/main.Admin/Snapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Snapshot(ctx, req.(*Nothing))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListConsumers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Nothing)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListConsumers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/main.Admin/ListConsumers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListConsumers(ctx, req.(*Nothing))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_EffectiveACL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConsumerName)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).EffectiveACL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/main.Admin/EffectiveACL",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).EffectiveACL(ctx, req.(*ConsumerName))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Admin_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "main.Admin",
 	HandlerType: (*AdminServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Health",
+			Handler:    _Admin_Health_Handler,
+		},
+		{
+			MethodName: "Snapshot",
+			Handler:    _Admin_Snapshot_Handler,
+		},
+		{
+			MethodName: "ListConsumers",
+			Handler:    _Admin_ListConsumers_Handler,
+		},
+		{
+			MethodName: "EffectiveACL",
+			Handler:    _Admin_EffectiveACL_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "Logging",
@@ -379,6 +1113,22 @@ var _Admin_serviceDesc = grpc.ServiceDesc{
 			Handler:       _Admin_Statistics_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "StatisticsInteractive",
+			Handler:       _Admin_StatisticsInteractive_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DrainEvents",
+			Handler:       _Admin_DrainEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "AuditAccess",
+			Handler:       _Admin_AuditAccess_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "service.proto",
 }
@@ -513,33 +1263,68 @@ var _Biz_serviceDesc = grpc.ServiceDesc{
 	Metadata: "service.proto",
 }
 
-func init() { proto.RegisterFile("service.proto", fileDescriptor_service_8108dcf1dd6080ef) }
-
-var fileDescriptor_service_8108dcf1dd6080ef = []byte{
-	// 386 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x52, 0x5d, 0xab, 0xda, 0x40,
-	0x10, 0xbd, 0xf9, 0xba, 0xd7, 0x8c, 0x95, 0x7b, 0x19, 0x4a, 0x09, 0xa1, 0x50, 0x09, 0xb4, 0xf5,
-	0xbe, 0x04, 0xb1, 0x14, 0xda, 0x4a, 0x1f, 0x54, 0x7c, 0x28, 0xb4, 0x7d, 0x88, 0x7d, 0x97, 0x7c,
-	0x2c, 0x66, 0xd1, 0xdd, 0x95, 0xec, 0x1a, 0x48, 0xa1, 0xff, 0xa2, 0x3f, 0xb8, 0xec, 0x26, 0x2a,
-	0xfa, 0x22, 0x7d, 0x9b, 0x73, 0x66, 0xce, 0x99, 0xc3, 0x30, 0x30, 0x90, 0xa4, 0xaa, 0x69, 0x4e,
-	0xe2, 0x7d, 0x25, 0x94, 0x40, 0x97, 0xa5, 0x94, 0x47, 0x0c, 0xbc, 0x65, 0x4d, 0xb8, 0xc2, 0xd7,
-	0xe0, 0x2b, 0xca, 0x88, 0x54, 0x29, 0xdb, 0x07, 0xd6, 0xd0, 0x1a, 0x39, 0xc9, 0x99, 0xc0, 0x10,
-	0x7a, 0xb9, 0xe0, 0xf2, 0xc0, 0x48, 0x15, 0xd8, 0x43, 0x6b, 0xe4, 0x27, 0x27, 0x8c, 0xaf, 0xe0,
-	0x9e, 0x11, 0x55, 0x8a, 0x22, 0x70, 0x4c, 0xa7, 0x43, 0x88, 0xe0, 0x96, 0x42, 0xaa, 0xc0, 0x35,
-	0xac, 0xa9, 0xa3, 0xbf, 0x36, 0xb8, 0x2b, 0x95, 0xde, 0x5a, 0xf7, 0x11, 0xfc, 0xac, 0x59, 0x77,
-	0xae, 0xf6, 0xd0, 0x19, 0xf5, 0x27, 0x41, 0xac, 0xf3, 0xc6, 0x5a, 0x1c, 0xcf, 0x9b, 0x1f, 0xa6,
-	0xb5, 0xe4, 0xaa, 0x6a, 0x92, 0x5e, 0xd6, 0x41, 0x9c, 0x42, 0x3f, 0x6b, 0xd6, 0xa7, 0xa0, 0x8e,
-	0x11, 0x86, 0x17, 0xc2, 0x45, 0xd7, 0x6c, 0xa5, 0x90, 0x9d, 0x88, 0x70, 0x0a, 0x83, 0x0b, 0x5f,
-	0x7c, 0x02, 0x67, 0x4b, 0x1a, 0x13, 0xce, 0x4f, 0x74, 0x89, 0x2f, 0xc1, 0xab, 0xd3, 0xdd, 0x81,
-	0x98, 0x13, 0xb8, 0x49, 0x0b, 0xbe, 0xd8, 0x9f, 0xac, 0xf0, 0x2b, 0x3c, 0x5e, 0x79, 0xff, 0x8f,
-	0x3c, 0xfa, 0x0c, 0x2f, 0x74, 0xbe, 0x6f, 0x5c, 0x91, 0xaa, 0x4e, 0x77, 0xf8, 0x0c, 0x4f, 0xb4,
-	0xab, 0xd7, 0x92, 0xe4, 0x82, 0x17, 0xd2, 0x18, 0xb9, 0xc9, 0xe3, 0x91, 0x5f, 0xb5, 0x74, 0xf4,
-	0x06, 0x1e, 0x7e, 0x0a, 0x55, 0x52, 0xbe, 0xd1, 0xfe, 0xc5, 0x81, 0xb1, 0x76, 0x67, 0x2f, 0x69,
-	0xc1, 0xa4, 0x00, 0x6f, 0x56, 0x30, 0xca, 0xf1, 0x19, 0x1e, 0xbe, 0x8b, 0xcd, 0x46, 0x4f, 0x0e,
-	0xda, 0x9b, 0x74, 0xc2, 0xb0, 0xdf, 0x42, 0xf3, 0x08, 0xd1, 0xdd, 0xd8, 0xc2, 0x31, 0x80, 0xce,
-	0x43, 0xa5, 0xa2, 0xb9, 0x44, 0x3c, 0x5f, 0xf0, 0x98, 0x30, 0x84, 0x33, 0xa7, 0x15, 0x93, 0x3f,
-	0xe0, 0xcc, 0xe9, 0x6f, 0x7c, 0x0f, 0xde, 0xa2, 0x24, 0xf9, 0xf6, 0x7a, 0xc3, 0x25, 0x8c, 0xee,
-	0xf0, 0x2d, 0x38, 0xb3, 0xa2, 0xb8, 0x39, 0xf6, 0x0e, 0xdc, 0x5f, 0x44, 0xaa, 0x5b, 0x73, 0xd9,
-	0xbd, 0xf9, 0xe9, 0x0f, 0xff, 0x02, 0x00, 0x00, 0xff, 0xff, 0x03, 0x1d, 0xb2, 0x19, 0xe4, 0x02,
-	0x00, 0x00,
+func init() { proto.RegisterFile("service.proto", fileDescriptor_service_0f8bf1293be38936) }
+
+var fileDescriptor_service_0f8bf1293be38936 = []byte{
+	// 945 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x56, 0x5f, 0x73, 0xdb, 0x44,
+	0x10, 0xb7, 0x22, 0xf9, 0xdf, 0xca, 0x69, 0xdc, 0x6d, 0x5a, 0x54, 0x0f, 0x7f, 0x82, 0x18, 0xa8,
+	0xcb, 0x10, 0x4f, 0x48, 0x0a, 0xc3, 0x50, 0xfa, 0xe0, 0xb8, 0x1e, 0xea, 0xc1, 0x75, 0x19, 0x39,
+	0xc0, 0xa3, 0x90, 0xa5, 0x4b, 0x7c, 0x53, 0x4b, 0x32, 0xba, 0xb3, 0x07, 0xc3, 0xf0, 0xc2, 0x37,
+	0xe2, 0x99, 0xcf, 0xc0, 0x77, 0x62, 0xee, 0x4e, 0x92, 0x2d, 0x37, 0xa9, 0x27, 0x0f, 0x7d, 0xf2,
+	0xed, 0x6f, 0xf7, 0xf7, 0xdb, 0xbd, 0xbb, 0xd5, 0x9e, 0x61, 0x9f, 0x91, 0x64, 0x49, 0x7d, 0xd2,
+	0x99, 0x27, 0x31, 0x8f, 0xd1, 0x08, 0x3d, 0x1a, 0xd9, 0xff, 0x6a, 0x50, 0xee, 0x2f, 0x49, 0xc4,
+	0xf1, 0x7d, 0xa8, 0x73, 0x1a, 0x12, 0xc6, 0xbd, 0x70, 0x6e, 0x69, 0x47, 0x5a, 0x5b, 0x77, 0xd6,
+	0x00, 0xb6, 0xa0, 0xe6, 0xc7, 0x11, 0x5b, 0x84, 0x24, 0xb1, 0xf6, 0x8e, 0xb4, 0x76, 0xdd, 0xc9,
+	0x6d, 0x7c, 0x00, 0x95, 0x90, 0xf0, 0x69, 0x1c, 0x58, 0xba, 0xf4, 0xa4, 0x16, 0x22, 0x18, 0xd3,
+	0x98, 0x71, 0xcb, 0x90, 0xa8, 0x5c, 0xe3, 0x43, 0xa8, 0xf1, 0xc4, 0xf3, 0x89, 0x4b, 0x03, 0xab,
+	0x2c, 0xf1, 0xaa, 0xb4, 0x07, 0x01, 0x7e, 0x00, 0x90, 0x90, 0xdf, 0x16, 0x84, 0x71, 0xe1, 0xac,
+	0x48, 0x67, 0x3d, 0x45, 0x06, 0x52, 0xcd, 0x8f, 0x03, 0x62, 0x55, 0x8f, 0xb4, 0x76, 0xd9, 0x91,
+	0x6b, 0xfb, 0x4f, 0x30, 0xbb, 0xbe, 0x4f, 0x18, 0x7b, 0x57, 0x5b, 0x68, 0x41, 0x2d, 0x20, 0x3e,
+	0x65, 0x34, 0x8e, 0xd2, 0x6d, 0xe4, 0xb6, 0x7d, 0x0e, 0x0d, 0x95, 0xfc, 0x39, 0x89, 0x28, 0x09,
+	0x0a, 0xfa, 0xda, 0x8d, 0xfa, 0x7b, 0x9b, 0xfa, 0xf6, 0xdf, 0x55, 0x30, 0xc6, 0xdc, 0xdb, 0x55,
+	0xfa, 0x57, 0x50, 0x9f, 0xac, 0xdc, 0x5c, 0x41, 0x6f, 0x9b, 0xa7, 0x56, 0x47, 0xdc, 0x5f, 0x47,
+	0x90, 0x3b, 0xe7, 0xab, 0x97, 0xd2, 0xd5, 0x8f, 0x78, 0xb2, 0x72, 0x6a, 0x93, 0xd4, 0xc4, 0xa7,
+	0x60, 0x4e, 0x56, 0x6e, 0x5e, 0x94, 0x2e, 0x89, 0xad, 0x02, 0xb1, 0x97, 0x3a, 0x15, 0x15, 0x26,
+	0x39, 0x80, 0x2f, 0xa0, 0x49, 0x92, 0x24, 0x4e, 0x98, 0xbb, 0x4e, 0x6d, 0x48, 0x85, 0x0f, 0x37,
+	0x14, 0xfa, 0x32, 0xa4, 0x58, 0xc0, 0x1d, 0x52, 0x00, 0x71, 0x04, 0xb8, 0x56, 0xca, 0xab, 0x29,
+	0x4b, 0xad, 0xa3, 0x6b, 0xb4, 0x8a, 0x35, 0x35, 0xc9, 0x16, 0x8c, 0x16, 0x54, 0x83, 0x24, 0x9e,
+	0xcf, 0x89, 0xea, 0x12, 0xc3, 0xc9, 0x4c, 0xbc, 0x80, 0xc3, 0x19, 0xbd, 0x24, 0xe2, 0xe0, 0x0a,
+	0xb9, 0xaa, 0x32, 0x97, 0xbd, 0x91, 0x6b, 0x98, 0x86, 0x6d, 0x67, 0xc3, 0xd9, 0x1b, 0x0e, 0x7c,
+	0x02, 0x0f, 0x3c, 0x9f, 0xd3, 0x25, 0x71, 0x67, 0xf1, 0xd5, 0x15, 0x8d, 0xae, 0x5c, 0xc6, 0x13,
+	0xe2, 0x85, 0xcc, 0xaa, 0xc9, 0xf4, 0x87, 0xca, 0x3b, 0x54, 0xce, 0xb1, 0xf2, 0x61, 0x07, 0xee,
+	0xa5, 0x2c, 0xc6, 0x3d, 0x9e, 0x53, 0xea, 0x92, 0x72, 0x57, 0xb9, 0x44, 0x29, 0x59, 0xfc, 0x31,
+	0x60, 0x1a, 0xef, 0xc7, 0x51, 0x44, 0x7c, 0x4e, 0xe3, 0x88, 0x59, 0xb0, 0x19, 0xde, 0x5b, 0x3b,
+	0x5a, 0x4f, 0x61, 0xbf, 0x70, 0xea, 0xd8, 0x04, 0xfd, 0x35, 0x59, 0xa5, 0x9d, 0x27, 0x96, 0x78,
+	0x08, 0xe5, 0xa5, 0x37, 0x5b, 0x10, 0xd9, 0x73, 0x86, 0xa3, 0x8c, 0x6f, 0xf7, 0xbe, 0xd1, 0x5a,
+	0xcf, 0xe0, 0x60, 0x6b, 0xe3, 0xb7, 0xa2, 0x77, 0xe1, 0xde, 0x35, 0xf7, 0x7e, 0x2b, 0x89, 0x1e,
+	0xdc, 0xbf, 0xf6, 0xba, 0x6f, 0x25, 0xd2, 0x87, 0xf7, 0x6e, 0xb8, 0xc7, 0xdb, 0xc8, 0xd8, 0xff,
+	0x68, 0xd0, 0x10, 0x37, 0x31, 0x88, 0x38, 0x49, 0x96, 0xde, 0x0c, 0x1f, 0x43, 0x93, 0xa6, 0x6b,
+	0x97, 0x11, 0x3f, 0x8e, 0x02, 0x26, 0x95, 0x0c, 0xe7, 0x20, 0xc3, 0xc7, 0x0a, 0xc6, 0x8f, 0xc0,
+	0xcc, 0x43, 0x43, 0x96, 0x6a, 0x43, 0x06, 0xbd, 0x64, 0xf8, 0x31, 0x34, 0x18, 0x89, 0x02, 0x97,
+	0x46, 0x94, 0x53, 0x6f, 0x26, 0xe7, 0x4b, 0xcd, 0x31, 0x05, 0x36, 0x50, 0x50, 0x61, 0x70, 0x18,
+	0x37, 0x0e, 0x8e, 0x72, 0x61, 0x70, 0x2c, 0xa1, 0xf1, 0x82, 0x78, 0x33, 0x3e, 0x15, 0x85, 0x2f,
+	0x18, 0x7e, 0x09, 0x15, 0x26, 0x57, 0xb2, 0xd0, 0x3b, 0xa7, 0x0f, 0x55, 0xaf, 0x6f, 0xc6, 0x74,
+	0xd4, 0x8f, 0x93, 0x06, 0xda, 0x67, 0x50, 0x49, 0xc9, 0x26, 0x54, 0x7f, 0x1a, 0xfd, 0x30, 0x7a,
+	0xf5, 0xcb, 0xa8, 0x59, 0x12, 0xc6, 0xb8, 0xef, 0xfc, 0x3c, 0x18, 0x7d, 0xdf, 0xd4, 0xf0, 0x00,
+	0xcc, 0xd1, 0xab, 0x0b, 0x37, 0x03, 0xf6, 0xec, 0x2f, 0xa0, 0x91, 0x1d, 0xf4, 0x90, 0x32, 0x39,
+	0xb7, 0xb2, 0x5a, 0x45, 0x6a, 0x5d, 0xcc, 0xec, 0x1c, 0xb0, 0x3f, 0x5f, 0x47, 0x8f, 0xbc, 0x90,
+	0xbc, 0x6d, 0x44, 0xda, 0xdf, 0x01, 0x74, 0x7b, 0x43, 0x71, 0x7b, 0x94, 0x30, 0x71, 0x5b, 0xc9,
+	0x62, 0x46, 0x32, 0x4d, 0x65, 0x88, 0x2f, 0x5f, 0xed, 0x9f, 0xc9, 0x29, 0x58, 0x77, 0x32, 0xd3,
+	0xfe, 0x15, 0xaa, 0xa3, 0x98, 0x4f, 0x69, 0x74, 0x25, 0xa8, 0xc1, 0x22, 0x0c, 0xd5, 0xe5, 0xd7,
+	0x1c, 0x65, 0xbc, 0x75, 0xfa, 0x7f, 0x02, 0xfb, 0x4a, 0xc7, 0x9d, 0x27, 0xe4, 0x92, 0xfe, 0x9e,
+	0x3e, 0x02, 0x0d, 0x05, 0xfe, 0x28, 0xb1, 0xd3, 0xff, 0x74, 0x28, 0x77, 0x83, 0x90, 0x46, 0xf8,
+	0x18, 0xaa, 0xe9, 0xb7, 0x8e, 0xfb, 0xea, 0x98, 0xd3, 0xd4, 0x2d, 0x53, 0x99, 0xf2, 0x35, 0xb2,
+	0x4b, 0x27, 0x1a, 0x9e, 0x00, 0x88, 0x33, 0xa6, 0x8c, 0x53, 0x9f, 0x21, 0xae, 0x07, 0x50, 0xd6,
+	0x6b, 0x2d, 0x58, 0x63, 0x92, 0xf1, 0x0c, 0xee, 0xaf, 0x19, 0x32, 0x4a, 0x7d, 0xfb, 0xbb, 0xc9,
+	0x6d, 0xed, 0x44, 0xc3, 0x63, 0x30, 0x9f, 0x27, 0x1e, 0x8d, 0x64, 0x09, 0x6c, 0x67, 0x7d, 0x67,
+	0x60, 0x76, 0x17, 0x01, 0xe5, 0xea, 0x21, 0xdb, 0x0e, 0xbf, 0xab, 0xcc, 0x8d, 0x27, 0x56, 0x92,
+	0x8e, 0xa1, 0xa2, 0xfa, 0x6a, 0x3b, 0x1e, 0xdf, 0x6c, 0x3a, 0xbb, 0x84, 0x8f, 0xa0, 0x36, 0x8e,
+	0xbc, 0x39, 0x9b, 0xc6, 0x7c, 0x9b, 0x50, 0xa8, 0x1f, 0x9f, 0xc0, 0xbe, 0xe8, 0xa9, 0xac, 0x63,
+	0xd8, 0x0d, 0xf2, 0x9b, 0xfd, 0x67, 0x97, 0xf0, 0x6b, 0x68, 0xf4, 0x2f, 0x2f, 0x89, 0x3c, 0xa4,
+	0x6e, 0x6f, 0x88, 0x5b, 0x51, 0xa2, 0xef, 0x5a, 0xcd, 0x74, 0x23, 0x79, 0x7f, 0xd9, 0xa5, 0xd3,
+	0xbf, 0x40, 0x3f, 0xa7, 0x7f, 0xe0, 0x23, 0x28, 0xf7, 0xa6, 0xc4, 0x7f, 0xbd, 0x9d, 0xac, 0x68,
+	0xda, 0x25, 0xfc, 0x14, 0xf4, 0x6e, 0x10, 0xec, 0x0c, 0xfb, 0x0c, 0x8c, 0x0b, 0xc2, 0xf8, 0xae,
+	0xb8, 0x49, 0x45, 0xfe, 0x0b, 0x3b, 0xfb, 0x7f, 0x00, 0x55, 0x65, 0x53, 0x29, 0x96, 0x09, 0x00,
+	0x00,
 }
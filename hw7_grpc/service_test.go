@@ -1,20 +1,33 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -47,6 +60,65 @@ func getGrpcConn(t *testing.T) *grpc.ClientConn {
 	return grcpConn
 }
 
+// поднимает сервис на свободном порту и возвращает клиентов, когда он уже
+// принимает вызовы. cleanup закрывает соединение и ждет остановки сервиса
+func startTestService(t *testing.T, acl string, options ...Option) (BizClient, AdminClient, func()) {
+	_, conn, stop := startTestServer(t, acl, options...)
+
+	cleanup := func() {
+		conn.Close()
+		stop()
+	}
+
+	return NewBizClient(conn), NewAdminClient(conn), cleanup
+}
+
+// то же, но для тестов, которым нужен сам сервис. stop отменяет его контекст
+// и ждет остановки, соединение остается открытым, его закрывает тест.
+// stop можно звать несколько раз
+func startTestServer(t *testing.T, acl string, options ...Option) (*service, *grpc.ClientConn, func()) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, "127.0.0.1:0", acl, options...)
+	if err != nil {
+		finish()
+		t.Fatalf("cant start server initial: %v", err)
+	}
+
+	select {
+	case <-srv.serving:
+	case <-srv.stopped:
+		finish()
+		t.Fatalf("server stopped before serving: %v", srv.stopErr)
+	}
+
+	conn, err := grpc.Dial(srv.addr, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(time.Second))
+	if err != nil {
+		finish()
+		<-srv.stopped
+		t.Fatalf("cant connect to grpc: %v", err)
+	}
+
+	stop := func() {
+		finish()
+		<-srv.stopped
+	}
+
+	return srv, conn, stop
+}
+
+// ждет, пока сервис зарегистрирует нужное число стримов Logging и
+// Statistics, вместо того чтобы спать наугад
+func waitListeners(t *testing.T, srv *service, logging, stats int) {
+	deadline := time.Now().Add(time.Second)
+	for srv.ListenerCount() != logging || srv.StatListenerCount() != stats {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d logging and %d statistics streams, have %d and %d",
+				logging, stats, srv.ListenerCount(), srv.StatListenerCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 // получаем контекст с нужнымы метаданными для ACL
 func getConsumerCtx(consumerName string) context.Context {
 	// ctx, _ := context.WithTimeout(context.Background(), time.Second)
@@ -60,7 +132,7 @@ func getConsumerCtx(consumerName string) context.Context {
 // старт-стоп сервера
 func TestServerStartStop(t *testing.T) {
 	ctx, finish := context.WithCancel(context.Background())
-	err := StartMyMicroservice(ctx, listenAddr, ACLData)
+	_, err := StartMyMicroservice(ctx, listenAddr, ACLData)
 	if err != nil {
 		t.Fatalf("cant start server initial: %v", err)
 	}
@@ -70,7 +142,7 @@ func TestServerStartStop(t *testing.T) {
 
 	// теперь проверим что вы освободили порт и мы можем стартовать сервер ещё раз
 	ctx, finish = context.WithCancel(context.Background())
-	err = StartMyMicroservice(ctx, listenAddr, ACLData)
+	_, err = StartMyMicroservice(ctx, listenAddr, ACLData)
 	if err != nil {
 		t.Fatalf("cant start server again: %v", err)
 	}
@@ -103,7 +175,7 @@ func TestServerLeak(t *testing.T) {
 // ACL (права на методы доступа) парсится корректно
 func TestACLParseError(t *testing.T) {
 	// finish'а тут нет потому что стартовать у вас ничего не должно если не получилось распаковать ACL
-	err := StartMyMicroservice(context.Background(), listenAddr, "{.;")
+	_, err := StartMyMicroservice(context.Background(), listenAddr, "{.;")
 	if err == nil {
 		t.Fatalf("expacted error on bad acl json, have nil")
 	}
@@ -113,7 +185,7 @@ func TestACLParseError(t *testing.T) {
 func TestACL(t *testing.T) {
 	wait(1)
 	ctx, finish := context.WithCancel(context.Background())
-	err := StartMyMicroservice(ctx, listenAddr, ACLData)
+	_, err := StartMyMicroservice(ctx, listenAddr, ACLData)
 	if err != nil {
 		t.Fatalf("cant start server initial: %v", err)
 	}
@@ -129,16 +201,19 @@ func TestACL(t *testing.T) {
 	biz := NewBizClient(conn)
 	adm := NewAdminClient(conn)
 
-	for idx, ctx := range []context.Context{
-		context.Background(),       // нет поля для ACL
-		getConsumerCtx("unknown"),  // поле есть, неизвестный консюмер
-		getConsumerCtx("biz_user"), // поле есть, нет доступа
+	for idx, tc := range []struct {
+		ctx  context.Context
+		code codes.Code
+	}{
+		{context.Background(), codes.Unauthenticated},        // нет поля для ACL
+		{getConsumerCtx("unknown"), codes.Unauthenticated},   // поле есть, неизвестный консюмер
+		{getConsumerCtx("biz_user"), codes.PermissionDenied}, // поле есть, нет доступа
 	} {
-		_, err = biz.Test(ctx, &Nothing{})
+		_, err = biz.Test(tc.ctx, &Nothing{})
 		if err == nil {
 			t.Fatalf("[%d] ACL fail: expected err on disallowed method", idx)
-		} else if code := grpc.Code(err); code != codes.Unauthenticated {
-			t.Fatalf("[%d] ACL fail: expected Unauthenticated code, got %v", idx, code)
+		} else if code := grpc.Code(err); code != tc.code {
+			t.Fatalf("[%d] ACL fail: expected %v code, got %v", idx, tc.code, code)
 		}
 	}
 
@@ -168,7 +243,7 @@ func TestACL(t *testing.T) {
 
 func TestLogging(t *testing.T) {
 	ctx, finish := context.WithCancel(context.Background())
-	err := StartMyMicroservice(ctx, listenAddr, ACLData)
+	_, err := StartMyMicroservice(ctx, listenAddr, ACLData)
 	if err != nil {
 		t.Fatalf("cant start server initial: %v", err)
 	}
@@ -221,6 +296,7 @@ func TestLogging(t *testing.T) {
 			}
 			evt.Host = "" // для тестов
 			evt.Timestamp = 0
+			evt.RequestId = ""
 			logData1 = append(logData1, evt)
 		}
 	}()
@@ -239,6 +315,7 @@ func TestLogging(t *testing.T) {
 			}
 			evt.Host = "" // для тестов
 			evt.Timestamp = 0
+			evt.RequestId = ""
 			logData2 = append(logData2, evt)
 		}
 	}()
@@ -274,7 +351,7 @@ func TestLogging(t *testing.T) {
 
 func TestStat(t *testing.T) {
 	ctx, finish := context.WithCancel(context.Background())
-	err := StartMyMicroservice(ctx, listenAddr, ACLData)
+	_, err := StartMyMicroservice(ctx, listenAddr, ACLData)
 	if err != nil {
 		t.Fatalf("cant start server initial: %v", err)
 	}
@@ -314,6 +391,8 @@ func TestStat(t *testing.T) {
 			mu.Lock()
 			stat1 = stat
 			stat1.Timestamp = 0
+			stat1.LifetimeByConsumer = nil
+			stat1.ActiveLoggingStreams, stat1.ActiveStatStreams, stat1.ActiveConnections = 0, 0, 0
 			mu.Unlock()
 		}
 	}()
@@ -331,6 +410,8 @@ func TestStat(t *testing.T) {
 			mu.Lock()
 			stat2 = stat
 			stat2.Timestamp = 0
+			stat2.LifetimeByConsumer = nil
+			stat2.ActiveLoggingStreams, stat2.ActiveStatStreams, stat2.ActiveConnections = 0, 0, 0
 			mu.Unlock()
 		}
 	}()
@@ -402,6 +483,5243 @@ func TestStat(t *testing.T) {
 	finish()
 }
 
+func TestStatLifetimeByConsumer(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	statCtx, statCancel := context.WithCancel(getConsumerCtx("stat"))
+	defer statCancel()
+	statStream, err := adm.Statistics(statCtx, &StatInterval{IntervalMs: 300})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+
+	stat1, err := statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+
+	stat2, err := statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stat1.ByConsumer["biz_user"] != 2 || stat2.ByConsumer["biz_user"] != 1 {
+		t.Fatalf("expected window counts of 2 and 1, have %+v and %+v", stat1, stat2)
+	}
+	if stat1.LifetimeByConsumer["biz_user"] != 2 {
+		t.Fatalf("expected a lifetime count of 2, have %+v", stat1)
+	}
+	// the window was reset, the lifetime count was not
+	if stat2.LifetimeByConsumer["biz_user"] != 3 {
+		t.Fatalf("expected a lifetime count of 3, have %+v", stat2)
+	}
+	if stat2.LifetimeByConsumer["stat"] != 1 {
+		t.Fatalf("expected the Statistics call in the lifetime counts, have %+v", stat2)
+	}
+}
+
+func TestGrantTemporary(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	_, err = biz.Test(getConsumerCtx("biz_user"), &Nothing{})
+	if err == nil {
+		t.Fatalf("expected err before grant, have nil")
+	}
+
+	srv.GrantTemporary("biz_user", "/main.Biz/Test", 200*time.Millisecond)
+
+	_, err = biz.Test(getConsumerCtx("biz_user"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error while granted: %v", err)
+	}
+
+	wait(30)
+
+	_, err = biz.Test(getConsumerCtx("biz_user"), &Nothing{})
+	if err == nil {
+		t.Fatalf("expected err after grant expired, have nil")
+	} else if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied code, got %v", code)
+	}
+}
+
+func TestStatWindowBoundary(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	statStream, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	// both reach the stream before the first tick, but the second one was
+	// intercepted after the first window ended and has to go to the next one
+	now := time.Now()
+	srv.incomingStatCh <- &statMsg{consumerName: "early", methodName: "/main.Biz/Check", at: now}
+	srv.incomingStatCh <- &statMsg{consumerName: "late", methodName: "/main.Biz/Check", at: now.Add(1500 * time.Millisecond)}
+
+	expected := []map[string]uint64{
+		{"early": 1},
+		{"late": 1},
+	}
+	for i, want := range expected {
+		stat, err := statStream.Recv()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(stat.ByConsumer, want) {
+			t.Fatalf("window %d dont match\nhave %+v\nwant %+v", i+1, stat.ByConsumer, want)
+		}
+	}
+}
+
+func TestActiveConsumers(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData, WithActiveWindow(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Test(getConsumerCtx("biz_admin"), &Nothing{})
+	wait(1)
+
+	expected := []string{"biz_admin", "biz_user"}
+	if active := srv.ActiveConsumers(); !reflect.DeepEqual(active, expected) {
+		t.Fatalf("active consumers dont match\nhave %+v\nwant %+v", active, expected)
+	}
+
+	wait(30)
+
+	if active := srv.ActiveConsumers(); len(active) != 0 {
+		t.Fatalf("expected idle consumers to age out, have %+v", active)
+	}
+
+	// an open stream keeps its consumer active past the window
+	logCtx, logCancel := context.WithCancel(getConsumerCtx("logger"))
+	if _, err := NewAdminClient(conn).Logging(logCtx, &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(30)
+
+	if active := srv.ActiveConsumers(); !reflect.DeepEqual(active, []string{"logger"}) {
+		t.Fatalf("expected open stream to stay active, have %+v", active)
+	}
+
+	logCancel()
+	wait(30)
+
+	if active := srv.ActiveConsumers(); len(active) != 0 {
+		t.Fatalf("expected closed stream to age out, have %+v", active)
+	}
+}
+
+func TestListConsumers(t *testing.T) {
+	acl := `{
+	"ops":       ["/main.Admin/ListConsumers"],
+	"biz_user":  ["/main.Biz/Check"],
+	"biz_admin": ["/main.Biz/*"]
+}`
+	biz, adm, cleanup := startTestService(t, acl, WithActiveWindow(200*time.Millisecond))
+	defer cleanup()
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Test(getConsumerCtx("biz_admin"), &Nothing{})
+	wait(1)
+
+	list, err := adm.ListConsumers(getConsumerCtx("ops"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"biz_admin", "biz_user"}
+	if !reflect.DeepEqual(list.Consumers, expected) {
+		t.Fatalf("consumers dont match\nhave %+v\nwant %+v", list.Consumers, expected)
+	}
+
+	// biz_admin goes quiet for longer than the window
+	wait(30)
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	wait(1)
+
+	list, err = adm.ListConsumers(getConsumerCtx("ops"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = []string{"biz_user"}
+	if !reflect.DeepEqual(list.Consumers, expected) {
+		t.Fatalf("consumers dont match\nhave %+v\nwant %+v", list.Consumers, expected)
+	}
+
+	_, err = adm.ListConsumers(getConsumerCtx("biz_user"), &Nothing{})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied code, got %v", err)
+	}
+}
+
+func TestEffectiveACL(t *testing.T) {
+	acl := `{
+	"support":  ["/main.Admin/EffectiveACL"],
+	"biz_user": ["/main.Biz/*", "!/main.Biz/Test"],
+	"*":        ["/main.Admin/Health"]
+}`
+	_, adm, cleanup := startTestService(t, acl)
+	defer cleanup()
+
+	entries, err := adm.EffectiveACL(getConsumerCtx("support"), &ConsumerName{Consumer: "biz_user"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedRules := []string{"/main.Biz/*", "!/main.Biz/Test", "/main.Admin/Health"}
+	if !reflect.DeepEqual(entries.Rules, expectedRules) {
+		t.Fatalf("rules dont match\nhave %+v\nwant %+v", entries.Rules, expectedRules)
+	}
+	expectedMethods := []string{"/main.Admin/Health", "/main.Biz/Add", "/main.Biz/Check"}
+	if !reflect.DeepEqual(entries.Methods, expectedMethods) {
+		t.Fatalf("methods dont match\nhave %+v\nwant %+v", entries.Methods, expectedMethods)
+	}
+
+	// a consumer without the entry can not look, not even at itself
+	_, err = adm.EffectiveACL(getConsumerCtx("biz_user"), &ConsumerName{Consumer: "biz_user"})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData)
+	defer stop()
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	statCtx, statCancel := context.WithCancel(getConsumerCtx("stat"))
+	defer statCancel()
+	statStream, err := adm.Statistics(statCtx, &StatInterval{IntervalMs: 200})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitListeners(t, srv, 0, 1)
+
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	srv.Drain()
+
+	_, err = biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	if code := grpc.Code(err); code != codes.Unavailable {
+		t.Fatalf("expected Unavailable code while draining, got %v", err)
+	}
+	health, err := adm.Health(context.Background(), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if health.Status != HealthStatus_NOT_SERVING {
+		t.Fatalf("expected NOT_SERVING while draining, have %v", health.Status)
+	}
+
+	// the stream goes on and still reports the call made before
+	stat, err := statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stat.ByMethod["/main.Biz/Check"] != 1 {
+		t.Fatalf("expected the Check call before draining, have %+v", stat)
+	}
+	if _, err := statStream.Recv(); err != nil {
+		t.Fatalf("expected the stream to keep ticking, got %v", err)
+	}
+}
+
+func TestStopAdmin(t *testing.T) {
+	acl := `{
+	"logger":   ["/main.Admin/Logging"],
+	"stat":     ["/main.Admin/Statistics"],
+	"ops":      ["/main.Admin/Snapshot", "/main.Admin/ListConsumers", "/main.Admin/EffectiveACL"],
+	"biz_user": ["/main.Biz/Check"]
+}`
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, acl)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	logStream, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	statStream, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	srv.StopAdmin()
+
+	if _, err := logStream.Recv(); err != io.EOF {
+		t.Fatalf("expected logging stream to end, got %v", err)
+	}
+	if _, err := statStream.Recv(); err != io.EOF {
+		t.Fatalf("expected statistics stream to end, got %v", err)
+	}
+
+	_, err = biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected biz error after StopAdmin: %v", err)
+	}
+
+	logStream, err = adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = logStream.Recv()
+	if code := grpc.Code(err); code != codes.Unavailable {
+		t.Fatalf("expected Unavailable code, got %v", code)
+	}
+
+	// unary admin calls are refused the same way, Health stays open
+	_, err = adm.Snapshot(getConsumerCtx("ops"), &Nothing{})
+	if code := grpc.Code(err); code != codes.Unavailable {
+		t.Fatalf("expected Unavailable code for Snapshot, got %v", code)
+	}
+	_, err = adm.ListConsumers(getConsumerCtx("ops"), &Nothing{})
+	if code := grpc.Code(err); code != codes.Unavailable {
+		t.Fatalf("expected Unavailable code for ListConsumers, got %v", code)
+	}
+	_, err = adm.EffectiveACL(getConsumerCtx("ops"), &ConsumerName{Consumer: "biz_user"})
+	if code := grpc.Code(err); code != codes.Unavailable {
+		t.Fatalf("expected Unavailable code for EffectiveACL, got %v", code)
+	}
+	if _, err := adm.Health(context.Background(), &Nothing{}); err != nil {
+		t.Fatalf("unexpected health error after StopAdmin: %v", err)
+	}
+}
+
+func TestStopAdminUnservedListener(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	// registered, but nobody reads closeCh or closes doneCh
+	unserved := &listener{
+		logsCh:  make(chan *logMsg, listenerBufferSize),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	if _, err := srv.addListener(unserved); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// there is no stream handler to do it
+	srv.streams.Done()
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.StopAdmin()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("StopAdmin blocked on an unserved listener")
+	}
+
+	_, err = biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected biz error after StopAdmin: %v", err)
+	}
+}
+
+func TestDroppedListeners(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	// listeners whose streams went away without unregistering
+	dead := &listener{
+		logsCh:  make(chan *logMsg),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	close(dead.doneCh)
+	if _, err := srv.addListener(dead); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// there is no stream handler to do it
+	srv.streams.Done()
+
+	deadStat := &statListener{
+		statCh:  make(chan *statMsg),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	close(deadStat.doneCh)
+	if err := srv.addStatListener(deadStat); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// there is no stream handler to do it
+	srv.streams.Done()
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	wait(1)
+
+	if dropped := srv.DroppedListeners(); dropped != 2 {
+		t.Fatalf("expected 2 dropped listeners, have %d", dropped)
+	}
+
+	srv.m.RLock()
+	listeners, statListeners := len(srv.listeners), len(srv.statListeners)
+	srv.m.RUnlock()
+	if listeners != 0 || statListeners != 0 {
+		t.Fatalf("expected closed listeners to be removed, have %d logging and %d stat", listeners, statListeners)
+	}
+}
+
+func TestCountDeniedCalls(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		options  []Option
+		expected map[string]uint64
+	}{
+		{
+			name:    "after acl",
+			options: nil,
+			expected: map[string]uint64{
+				"/main.Biz/Check": 1,
+			},
+		},
+		{
+			name:    "before acl",
+			options: []Option{WithCountDeniedCalls()},
+			expected: map[string]uint64{
+				"/main.Biz/Check": 1,
+				"/main.Biz/Test":  1,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, finish := context.WithCancel(context.Background())
+			_, err := StartMyMicroservice(ctx, listenAddr, ACLData, tc.options...)
+			if err != nil {
+				t.Fatalf("cant start server initial: %v", err)
+			}
+			wait(1)
+			defer func() {
+				finish()
+				wait(1)
+			}()
+
+			conn := getGrpcConn(t)
+			defer conn.Close()
+
+			biz := NewBizClient(conn)
+			adm := NewAdminClient(conn)
+
+			statStream, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalSeconds: 1})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			wait(1)
+
+			biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+			biz.Test(getConsumerCtx("biz_user"), &Nothing{})
+			biz.Test(context.Background(), &Nothing{})
+
+			stat, err := statStream.Recv()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(stat.ByMethod, tc.expected) {
+				t.Fatalf("stat dont match\nhave %+v\nwant %+v", stat.ByMethod, tc.expected)
+			}
+		})
+	}
+}
+
+func TestLogHistoryReplay(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := StartMyMicroservice(ctx, listenAddr, ACLData, WithLogHistory(100))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	const before, during = 3, 20
+	for i := 0; i < before; i++ {
+		biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	}
+
+	// calls keep coming while the subscriber replays history
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < during; i++ {
+			biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+		}
+	}()
+
+	logStream, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wg.Wait()
+
+	events := make(chan *Event, 100)
+	go func() {
+		for {
+			evt, err := logStream.Recv()
+			if err != nil {
+				return
+			}
+			events <- evt
+		}
+	}()
+
+	received := 0
+LOOP:
+	for {
+		select {
+		case evt := <-events:
+			if evt.Method == "/main.Biz/Check" {
+				received++
+			}
+		case <-time.After(300 * time.Millisecond):
+			break LOOP
+		}
+	}
+
+	if received != before+during {
+		t.Fatalf("expected every call exactly once, have %d events want %d", received, before+during)
+	}
+}
+
+type chanSink chan *Event
+
+func (s chanSink) Emit(event *Event) {
+	s <- event
+}
+
+func TestEventSinks(t *testing.T) {
+	sink1 := make(chanSink, 10)
+	sink2 := make(chanSink, 10)
+
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := StartMyMicroservice(ctx, listenAddr, ACLData, WithEventSinks(sink1, sink2))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Test(getConsumerCtx("biz_admin"), &Nothing{})
+
+	expected := []string{"/main.Biz/Check", "/main.Biz/Add", "/main.Biz/Test"}
+	for idx, sink := range []chanSink{sink1, sink2} {
+		for _, method := range expected {
+			select {
+			case evt := <-sink:
+				if evt.Method != method {
+					t.Fatalf("[%d] unexpected event method: have %v, want %v", idx, evt.Method, method)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("[%d] sink didnt receive %v", idx, method)
+			}
+		}
+	}
+}
+
+func TestEventSinkDrops(t *testing.T) {
+	// unbuffered and never read, so the worker blocks on the first event
+	stuck := make(chanSink)
+
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData, WithEventSinks(stuck))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		go func() {
+			for range stuck {
+			}
+		}()
+		finish()
+		wait(1)
+	}()
+
+	extra := 5
+	for i := 0; i < sinkBufferSize+extra; i++ {
+		srv.broadcastLog(&logMsg{methodName: "/main.Biz/Check", at: time.Now()})
+	}
+
+	if dropped := srv.DroppedEvents(); dropped < uint64(extra-1) {
+		t.Fatalf("expected at least %d dropped events, have %d", extra-1, dropped)
+	}
+}
+
+func TestSinkWorkerFlush(t *testing.T) {
+	sink := make(chanSink, 10)
+	w := &sinkWorker{
+		sink:   sink,
+		events: make(chan *Event, sinkBufferSize),
+	}
+	for i := 0; i < 3; i++ {
+		w.push(&Event{Method: "/main.Biz/Check"})
+	}
+
+	done := make(chan struct{})
+	close(done)
+	w.run(done)
+
+	if len(sink) != 3 {
+		t.Fatalf("expected buffered events to be flushed, have %d of 3", len(sink))
+	}
+}
+
+func TestFuncSinks(t *testing.T) {
+	events := make(chan *Event, 10)
+	stats := make(chan *Stat, 10)
+
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := StartMyMicroservice(ctx, listenAddr, ACLData,
+		WithLogSink(func(e *Event) { events <- e }),
+		WithStatSink(func(s *Stat) { stats <- s }))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Test(getConsumerCtx("biz_admin"), &Nothing{})
+
+	expected := []struct {
+		consumer string
+		method   string
+	}{
+		{"biz_user", "/main.Biz/Check"},
+		{"biz_admin", "/main.Biz/Test"},
+	}
+	for _, exp := range expected {
+		select {
+		case e := <-events:
+			if e.Consumer != exp.consumer || e.Method != exp.method {
+				t.Fatalf("unexpected event: have %+v, want %+v", e, exp)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("log sink didnt receive %v", exp.method)
+		}
+
+		select {
+		case s := <-stats:
+			if s.ByConsumer[exp.consumer] != 1 || s.ByMethod[exp.method] != 1 {
+				t.Fatalf("unexpected stat: have %+v, want %+v", s, exp)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("stat sink didnt receive %v", exp.method)
+		}
+	}
+}
+
+func TestStripConsumerFromHandlerContext(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData, WithStripConsumerFromHandlerContext())
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	var handlerCtx context.Context
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCtx = ctx
+		return &Nothing{}, nil
+	}
+
+	callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"consumer", "biz_user",
+		"x-other", "kept",
+	))
+	info := &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Check"}
+	_, err = srv.unaryInterceptor(callCtx, &Nothing{}, info, handler)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	md, _ := metadata.FromIncomingContext(handlerCtx)
+	if _, ok := md["consumer"]; ok {
+		t.Fatalf("raw consumer header leaked to the handler: %+v", md)
+	}
+	if other := md["x-other"]; len(other) != 1 || other[0] != "kept" {
+		t.Fatalf("other metadata should be kept, have %+v", md)
+	}
+	if consumer, ok := ConsumerFromContext(handlerCtx); !ok || consumer != "biz_user" {
+		t.Fatalf("expected typed consumer biz_user, have %q", consumer)
+	}
+
+	// the interceptor itself still sees the header
+	if md, _ := metadata.FromIncomingContext(callCtx); len(md["consumer"]) != 1 {
+		t.Fatalf("caller metadata must not be modified: %+v", md)
+	}
+}
+
+func TestDrainEvents(t *testing.T) {
+	acl := `{
+	"biz_user": ["/main.Biz/Check", "/main.Biz/Add"],
+	"drainer":  ["/main.Admin/DrainEvents"]
+}`
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := StartMyMicroservice(ctx, listenAddr, acl, WithLogHistory(10))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+
+	drain := func() []string {
+		stream, err := adm.DrainEvents(getConsumerCtx("drainer"), &Nothing{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		methods := []string{}
+		for {
+			evt, err := stream.Recv()
+			if err == io.EOF {
+				return methods
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			methods = append(methods, evt.Method)
+		}
+	}
+
+	expected := []string{"/main.Biz/Check", "/main.Biz/Add", "/main.Biz/Check"}
+	if methods := drain(); !reflect.DeepEqual(methods, expected) {
+		t.Fatalf("drained events dont match\nhave %+v\nwant %+v", methods, expected)
+	}
+
+	if methods := drain(); len(methods) != 0 {
+		t.Fatalf("expected history to be empty after drain, have %+v", methods)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	logStream, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	go func() {
+		for {
+			if _, err := logStream.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Test(getConsumerCtx("biz_admin"), &Nothing{})
+	wait(1)
+
+	d := srv.Diagnostics()
+
+	if d.Uptime <= 0 {
+		t.Fatalf("expected positive uptime, have %v", d.Uptime)
+	}
+	// Logging calls are not counted, same as in Statistics
+	if d.TotalRequests != 3 {
+		t.Fatalf("expected 3 requests, have %v", d.TotalRequests)
+	}
+	if d.ActiveListeners != 1 {
+		t.Fatalf("expected 1 active listener, have %v", d.ActiveListeners)
+	}
+	if d.ACLConsumers != 4 {
+		t.Fatalf("expected 4 acl consumers, have %v", d.ACLConsumers)
+	}
+
+	expectedMethods := map[string]uint64{
+		"/main.Biz/Check": 1,
+		"/main.Biz/Add":   1,
+		"/main.Biz/Test":  1,
+	}
+	if !reflect.DeepEqual(d.ByMethod, expectedMethods) {
+		t.Fatalf("by method dont match\nhave %+v\nwant %+v", d.ByMethod, expectedMethods)
+	}
+
+	expectedConsumers := map[string]uint64{
+		"biz_user":  2,
+		"biz_admin": 1,
+	}
+	if !reflect.DeepEqual(d.ByConsumer, expectedConsumers) {
+		t.Fatalf("by consumer dont match\nhave %+v\nwant %+v", d.ByConsumer, expectedConsumers)
+	}
+}
+
+func TestPackageACL(t *testing.T) {
+	acl := `{
+	"pkg":   ["/main.*"],
+	"other": ["/other.*"]
+}`
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, acl)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	if _, err := biz.Check(getConsumerCtx("pkg"), &Nothing{}); err != nil {
+		t.Fatalf("expected biz call to be allowed, got %v", err)
+	}
+
+	statStream, err := adm.Statistics(getConsumerCtx("pkg"), &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := statStream.Recv(); err != nil {
+		t.Fatalf("expected admin call to be allowed, got %v", err)
+	}
+
+	_, err = biz.Check(getConsumerCtx("other"), &Nothing{})
+	if err == nil || grpc.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected other package to be denied, got %v", err)
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	logStream, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	traced := func(k, v string) context.Context {
+		return metadata.AppendToOutgoingContext(getConsumerCtx("biz_user"), k, v)
+	}
+
+	calls := []context.Context{
+		traced("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+		traced("x-trace-id", "abc123"),
+		getConsumerCtx("biz_user"),
+	}
+	expected := []string{"4bf92f3577b34da6a3ce929d0e0e4736", "abc123", ""}
+
+	for i, callCtx := range calls {
+		if _, err := biz.Check(callCtx, &Nothing{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		evt, err := logStream.Recv()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if evt.TraceId != expected[i] {
+			t.Fatalf("call %d: expected trace id %q, have %q", i, expected[i], evt.TraceId)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	biz, adm, cleanup := startTestService(t, ACLData)
+	defer cleanup()
+
+	logStream, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	var header metadata.MD
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}, grpc.Header(&header)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ids := header["x-request-id"]
+	if len(ids) != 1 || len(ids[0]) != 32 {
+		t.Fatalf("expected a generated request id in the header, have %v", ids)
+	}
+	evt, err := logStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if evt.RequestId != ids[0] {
+		t.Fatalf("expected request id %q in the event, have %q", ids[0], evt.RequestId)
+	}
+
+	// an id the client sent is kept
+	callCtx := metadata.AppendToOutgoingContext(getConsumerCtx("biz_user"), "x-request-id", "req-1")
+	header = nil
+	if _, err := biz.Check(callCtx, &Nothing{}, grpc.Header(&header)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := header["x-request-id"]; len(ids) != 1 || ids[0] != "req-1" {
+		t.Fatalf("expected request id req-1 in the header, have %v", ids)
+	}
+	evt, err = logStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if evt.RequestId != "req-1" {
+		t.Fatalf("expected request id req-1 in the event, have %q", evt.RequestId)
+	}
+
+	// rejected calls carry it too
+	header = nil
+	_, err = biz.Check(getConsumerCtx("unknown"), &Nothing{}, grpc.Header(&header))
+	if code := grpc.Code(err); code != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated code, got %v", err)
+	}
+	if len(header["x-request-id"]) != 1 {
+		t.Fatalf("expected a request id in the header of a rejected call, have %v", header)
+	}
+}
+
+func TestEventCode(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData)
+	defer stop()
+	defer conn.Close()
+
+	logStream, err := NewAdminClient(conn).Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitListeners(t, srv, 1, 0)
+
+	failing := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Internal, "broken")
+	}
+	callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("consumer", "biz_user"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Check"}
+
+	if _, err := srv.unaryInterceptor(callCtx, &Nothing{}, info, failing); grpc.Code(err) != codes.Internal {
+		t.Fatalf("expected Internal code, got %v", err)
+	}
+	evt, err := logStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if codes.Code(evt.Code) != codes.Internal {
+		t.Fatalf("expected the event to carry Internal, have %+v", evt)
+	}
+
+	// and a call that went fine is OK
+	if _, err := NewBizClient(conn).Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	evt, err = logStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if codes.Code(evt.Code) != codes.OK {
+		t.Fatalf("expected the event to carry OK, have %+v", evt)
+	}
+}
+
+func TestAuditAccess(t *testing.T) {
+	acl := `{
+	"auditor":  ["/main.Admin/AuditAccess"],
+	"biz_user": ["/main.Biz/Check"]
+}`
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, acl)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	auditStream, err := adm.AuditAccess(getConsumerCtx("auditor"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := biz.Add(getConsumerCtx("biz_user"), &Nothing{}); err == nil {
+		t.Fatalf("expected Add to be denied")
+	}
+
+	expected := []*AccessEvent{
+		{Consumer: "biz_user", Method: "/main.Biz/Check", Decision: "allow"},
+		{Consumer: "biz_user", Method: "/main.Biz/Add", Decision: "deny"},
+	}
+
+	for _, want := range expected {
+		have, err := auditStream.Recv()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if have.Timestamp == 0 {
+			t.Fatalf("expected timestamp to be set: %+v", have)
+		}
+		have.Timestamp = 0
+		if !reflect.DeepEqual(have, want) {
+			t.Fatalf("audit events dont match\nhave %+v\nwant %+v", have, want)
+		}
+	}
+}
+
+func TestMaxTrackedConsumers(t *testing.T) {
+	acl := `{
+	"stat": ["/main.Admin/Statistics"],
+	"c0":   ["/main.Biz/Check"],
+	"c1":   ["/main.Biz/Check"],
+	"c2":   ["/main.Biz/Check"],
+	"c3":   ["/main.Biz/Check"],
+	"c4":   ["/main.Biz/Check"]
+}`
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, acl, WithMaxTrackedConsumers(2))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	statStream, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	for i := 0; i < 5; i++ {
+		biz.Check(getConsumerCtx(fmt.Sprintf("c%d", i)), &Nothing{})
+	}
+	biz.Check(getConsumerCtx("c0"), &Nothing{})
+
+	stat, err := statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]uint64{
+		"c0":        2,
+		"c1":        1,
+		"__other__": 3,
+	}
+	if !reflect.DeepEqual(stat.ByConsumer, expected) {
+		t.Fatalf("by consumer dont match\nhave %+v\nwant %+v", stat.ByConsumer, expected)
+	}
+
+	// "stat" itself took one of the slots in the cumulative totals
+	expected = map[string]uint64{
+		"stat":      1,
+		"c0":        2,
+		"__other__": 4,
+	}
+	if d := srv.Diagnostics(); !reflect.DeepEqual(d.ByConsumer, expected) {
+		t.Fatalf("total by consumer dont match\nhave %+v\nwant %+v", d.ByConsumer, expected)
+	}
+}
+
+func TestListenError(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	ms, err := StartMyMicroservice(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		if err := ms.Wait(); err != nil {
+			t.Fatalf("unexpected serve error: %v", err)
+		}
+	}()
+
+	ctx2, finish2 := context.WithCancel(context.Background())
+	defer finish2()
+
+	if _, err := StartMyMicroservice(ctx2, listenAddr, ACLData); err == nil {
+		t.Fatalf("expected an error starting on a busy address")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "service.sock")
+
+	// a stale socket from an earlier run
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ctx, finish := context.WithCancel(context.Background())
+	ms, err := StartMyMicroservice(ctx, "unix://"+path, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	<-ms.Ready()
+
+	conn, err := grpc.Dial(path,
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("cant connect to grpc: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := NewBizClient(conn).Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	finish()
+	ms.Wait()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket to be removed, got %v", err)
+	}
+}
+
+func TestWithNetwork(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	ms, err := StartMyMicroservice(ctx, listenAddr, ACLData, WithNetwork("tcp4", "127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	<-ms.Ready()
+
+	if ms.Addr() == listenAddr {
+		t.Fatalf("expected the addr argument to be overridden, have %v", ms.Addr())
+	}
+	conn, err := grpc.Dial(ms.Addr(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("cant connect to grpc: %v", err)
+	}
+	if _, err := NewBizClient(conn).Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn.Close()
+	finish()
+	ms.Wait()
+
+	dir, err := ioutil.TempDir("", "hw7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hw7.sock")
+
+	ctx, finish = context.WithCancel(context.Background())
+	ms, err = StartMyMicroservice(ctx, listenAddr, ACLData, WithNetwork("unix", path))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	<-ms.Ready()
+
+	conn, err = grpc.Dial(path,
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("cant connect to grpc: %v", err)
+	}
+	if _, err := NewBizClient(conn).Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn.Close()
+	finish()
+	ms.Wait()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket to be removed, got %v", err)
+	}
+
+	_, err = StartMyMicroservice(context.Background(), listenAddr, ACLData, WithNetwork("udp", "127.0.0.1:0"))
+	if err == nil || !strings.Contains(err.Error(), `unsupported network "udp"`) {
+		t.Fatalf("expected an unsupported network error, got %v", err)
+	}
+}
+
+func TestLifecycleCallbacks(t *testing.T) {
+	events := make(chan string, 10)
+	onServing := func() {
+		events <- "serving"
+	}
+	onStopped := func(err error) {
+		events <- fmt.Sprintf("stopped %v", err)
+	}
+
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData, WithLifecycle(onServing, onStopped))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	finish()
+
+	var have []string
+	for len(have) < 2 {
+		select {
+		case e := <-events:
+			have = append(have, e)
+		case <-time.After(time.Second):
+			t.Fatalf("lifecycle callbacks not called, have %+v", have)
+		}
+	}
+	wait(1)
+
+	expected := []string{"serving", "stopped <nil>"}
+	if !reflect.DeepEqual(have, expected) {
+		t.Fatalf("lifecycle events dont match\nhave %+v\nwant %+v", have, expected)
+	}
+	if len(events) != 0 {
+		t.Fatalf("callbacks should fire once, extra %v", <-events)
+	}
+}
+
+func TestEventTimestamp(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	logStream, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	before := time.Now().Unix()
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after := time.Now().Unix()
+
+	evt, err := logStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if evt.Timestamp < before || evt.Timestamp > after {
+		t.Fatalf("timestamp %v is not within [%v, %v]", evt.Timestamp, before, after)
+	}
+}
+
+func TestEventHost(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	logStream, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	// dial biz calls from a port we know
+	localAddr := make(chan string, 1)
+	bizConn, err := grpc.Dial(
+		listenAddr,
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			c, err := net.DialTimeout("tcp", addr, timeout)
+			if err == nil {
+				localAddr <- c.LocalAddr().String()
+			}
+			return c, err
+		}),
+	)
+	if err != nil {
+		t.Fatalf("cant connect to grpc: %v", err)
+	}
+	defer bizConn.Close()
+
+	biz := NewBizClient(bizConn)
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	evt, err := logStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := <-localAddr; evt.Host != expected {
+		t.Fatalf("expected host %v, have %v", expected, evt.Host)
+	}
+
+	// no peer in the context, falls back to the listen address
+	if host := srv.getHostFromContext(context.Background()); host != listenAddr {
+		t.Fatalf("expected fallback host %v, have %v", listenAddr, host)
+	}
+}
+
+func TestLoggingListenerRemoved(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	const streams = 5
+	cancels := make([]context.CancelFunc, 0, streams)
+	for i := 0; i < streams; i++ {
+		logCtx, logCancel := context.WithCancel(getConsumerCtx("logger"))
+		if _, err := adm.Logging(logCtx, &Nothing{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cancels = append(cancels, logCancel)
+	}
+	wait(1)
+
+	srv.m.RLock()
+	listeners := len(srv.listeners)
+	srv.m.RUnlock()
+	if listeners != streams {
+		t.Fatalf("expected %d listeners, have %d", streams, listeners)
+	}
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	wait(1)
+
+	srv.m.RLock()
+	listeners = len(srv.listeners)
+	srv.m.RUnlock()
+	if listeners != 0 {
+		t.Fatalf("expected listeners to be removed, have %d", listeners)
+	}
+}
+
+func TestKeepaliveReclaimsListener(t *testing.T) {
+	srv, direct, stop := startTestServer(t, ACLData, WithKeepalive(keepalive.ServerParameters{
+		Time:    50 * time.Millisecond,
+		Timeout: 50 * time.Millisecond,
+	}))
+	defer stop()
+	defer direct.Close()
+
+	// the client goes through a proxy which, once frozen, swallows
+	// everything, as if the client machine was gone
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer proxy.Close()
+
+	var frozen int32
+	pipe := func(dst, src net.Conn) {
+		buf := make([]byte, 4096)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				return
+			}
+			if atomic.LoadInt32(&frozen) == 0 {
+				dst.Write(buf[:n])
+			}
+		}
+	}
+	go func() {
+		client, err := proxy.Accept()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		server, err := net.Dial("tcp", srv.addr)
+
+		if err != nil {
+			return
+		}
+		defer server.Close()
+
+		go pipe(server, client)
+		pipe(client, server)
+	}()
+
+	conn, err := grpc.Dial(proxy.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("cant connect to grpc: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := NewAdminClient(conn).Logging(getConsumerCtx("logger"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	listeners := func() int {
+		srv.m.RLock()
+		defer srv.m.RUnlock()
+		return len(srv.listeners)
+	}
+
+	// pings are answered while the client is there
+	wait(30)
+	if n := listeners(); n != 1 {
+		t.Fatalf("expected the stream to stay open, have %d listeners", n)
+	}
+
+	atomic.StoreInt32(&frozen, 1)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for listeners() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the listener of the dead client to be reclaimed")
+		}
+		wait(1)
+	}
+}
+
+type tagKey struct{}
+
+func TestCustomInterceptors(t *testing.T) {
+	var mu sync.Mutex
+	seen := []string{}
+	record := func(s string) {
+		mu.Lock()
+		seen = append(seen, s)
+		mu.Unlock()
+	}
+
+	tag := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		record("tag " + info.FullMethod)
+		return handler(context.WithValue(ctx, tagKey{}, "tagged"), req)
+	}
+	check := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		tagged, _ := ctx.Value(tagKey{}).(string)
+		record("check " + tagged)
+		return handler(ctx, req)
+	}
+	streamTag := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		record("stream " + info.FullMethod)
+		return handler(srv, ss)
+	}
+
+	biz, adm, cleanup := startTestService(t, ACLData,
+		WithUnaryInterceptors(tag, check), WithStreamInterceptors(streamTag))
+	defer cleanup()
+
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the custom ones run first, the ACL check still does
+	_, err := biz.Test(getConsumerCtx("biz_user"), &Nothing{})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+	stream, err := adm.Logging(getConsumerCtx("biz_user"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := stream.Recv(); grpc.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for the stream, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{
+		"tag /main.Biz/Check", "check tagged",
+		"tag /main.Biz/Test", "check tagged",
+		"stream /main.Admin/Logging",
+	}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Fatalf("interceptors ran wrong\nhave %v\nwant %v", seen, expected)
+	}
+}
+
+func TestMaxMsgSize(t *testing.T) {
+	long := strings.Repeat("x", 1024)
+	acl := fmt.Sprintf(`{
+	"biz_user": ["/main.Biz/Check"],
+	"admin":    ["/main.Admin/ListConsumers"],
+	%q: ["/main.Biz/Check"]
+}`, long)
+	biz, adm, cleanup := startTestService(t, acl, WithMaxMsgSize(512, 512))
+	defer cleanup()
+
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{Consumer: strings.Repeat("x", 100)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{Consumer: long})
+	if code := grpc.Code(err); code != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted for a message over the receive limit, got %v", err)
+	}
+
+	// a consumer with a long name makes the active consumer list too big
+	if _, err := biz.Check(getConsumerCtx(long), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = adm.ListConsumers(getConsumerCtx("admin"), &Nothing{})
+	if code := grpc.Code(err); code != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted for a response over the send limit, got %v", err)
+	}
+}
+
+func TestStatListenerRemoved(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	// get the connection up before counting goroutines
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	wait(1)
+	goroutinesStart := runtime.NumGoroutine()
+
+	const streams = 3
+	cancels := make([]context.CancelFunc, 0, streams)
+	for i := 0; i < streams; i++ {
+		statCtx, statCancel := context.WithCancel(getConsumerCtx("stat"))
+		if _, err := adm.Statistics(statCtx, &StatInterval{IntervalSeconds: 1}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cancels = append(cancels, statCancel)
+	}
+	wait(1)
+
+	srv.m.RLock()
+	statListeners := len(srv.statListeners)
+	srv.m.RUnlock()
+	if statListeners != streams {
+		t.Fatalf("expected %d stat listeners, have %d", streams, statListeners)
+	}
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	wait(5)
+
+	srv.m.RLock()
+	statListeners = len(srv.statListeners)
+	srv.m.RUnlock()
+	if statListeners != 0 {
+		t.Fatalf("expected stat listeners to be removed, have %d", statListeners)
+	}
+
+	if goroutines := runtime.NumGoroutine(); goroutines > goroutinesStart {
+		t.Fatalf("looks like statistics goroutines leak: %d before, %d after", goroutinesStart, goroutines)
+	}
+}
+
+func TestAuditListenerRemoved(t *testing.T) {
+	acl := `{"auditor": ["/main.Admin/AuditAccess"]}`
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, acl)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	auditCtx, auditCancel := context.WithCancel(getConsumerCtx("auditor"))
+	if _, err := adm.AuditAccess(auditCtx, &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	srv.m.RLock()
+	auditListeners := len(srv.auditListeners)
+	srv.m.RUnlock()
+	if auditListeners != 1 {
+		t.Fatalf("expected 1 audit listener, have %d", auditListeners)
+	}
+
+	auditCancel()
+	wait(5)
+
+	srv.m.RLock()
+	auditListeners = len(srv.auditListeners)
+	srv.m.RUnlock()
+	if auditListeners != 0 {
+		t.Fatalf("expected audit listener to be removed, have %d", auditListeners)
+	}
+}
+
+func TestLogDelivery(t *testing.T) {
+	// big events fill up the flow control window of the stream quickly
+	slowpoke := strings.Repeat("x", 2048)
+	acl := `{
+	"logger": ["/main.Admin/Logging"],
+	"` + slowpoke + `": ["/main.Biz/Check"]
+}`
+
+	// start opens a Logging stream that is never read and returns it once
+	// calls are delivered to it
+	start := func(t *testing.T, mode DeliveryMode) (*service, BizClient, Admin_LoggingClient, context.CancelFunc, func()) {
+		srv, direct, stop := startTestServer(t, acl,
+			WithListenerBufferSize(2), WithLogDelivery(mode, 100*time.Millisecond))
+
+		// a fixed window, it would grow with the events otherwise
+		conn, err := grpc.Dial(srv.addr, grpc.WithInsecure(),
+			grpc.WithInitialWindowSize(64*1024), grpc.WithInitialConnWindowSize(64*1024))
+		if err != nil {
+			t.Fatalf("cant connect to grpc: %v", err)
+		}
+
+		logCtx, logCancel := context.WithCancel(getConsumerCtx("logger"))
+		logStream, err := NewAdminClient(conn).Logging(logCtx, &Nothing{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		waitListeners(t, srv, 1, 0)
+
+		cleanup := func() {
+			logCancel()
+			conn.Close()
+			direct.Close()
+			stop()
+		}
+
+		return srv, NewBizClient(conn), logStream, logCancel, cleanup
+	}
+
+	// fill makes calls until one takes longer than a second, up to max
+	fill := func(biz BizClient, max int) (int, error) {
+		for i := 0; i < max; i++ {
+			callCtx, cancel := context.WithTimeout(getConsumerCtx(slowpoke), time.Second)
+			_, err := biz.Check(callCtx, &Nothing{})
+			cancel()
+			if err != nil {
+				return i, err
+			}
+		}
+		return max, nil
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		srv, biz, _, _, cleanup := start(t, DropOnFull)
+		defer cleanup()
+
+		if _, err := fill(biz, 300); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if srv.DroppedEvents() == 0 {
+			t.Fatalf("expected events to be dropped for the stalled stream")
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		srv, biz, _, logCancel, cleanup := start(t, BlockOnFull)
+		defer cleanup()
+
+		n, err := fill(biz, 300)
+		if grpc.Code(err) != codes.DeadlineExceeded {
+			t.Fatalf("expected a call to be held up after %d calls, got %v", n, err)
+		}
+		if srv.DroppedEvents() != 0 {
+			t.Fatalf("expected no drops, have %d", srv.DroppedEvents())
+		}
+
+		// the stream going away lets the calls through
+		logCancel()
+		if _, err := fill(biz, 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		srv, biz, logStream, _, cleanup := start(t, CloseOnFull)
+		defer cleanup()
+
+		if _, err := fill(biz, 300); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if srv.DroppedListeners() != 1 {
+			t.Fatalf("expected the stalled stream to be dropped, have %d", srv.DroppedListeners())
+		}
+
+		// what made it out comes first, then the stream ends
+		for {
+			_, err := logStream.Recv()
+			if err == nil {
+				continue
+			}
+			if code := grpc.Code(err); code != codes.DeadlineExceeded {
+				t.Fatalf("expected DeadlineExceeded code, got %v", err)
+			}
+			break
+		}
+	})
+}
+
+func TestListenerDropCounter(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	healthy, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	// room for two messages and nobody reading
+	stalled := &listener{
+		logsCh:  make(chan *logMsg, 2),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	defer close(stalled.doneCh)
+	if _, err := srv.addListener(stalled); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// there is no stream handler to do it
+	srv.streams.Done()
+
+	const calls = 10
+	for i := 0; i < calls; i++ {
+		biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	}
+
+	for i := 0; i < calls; i++ {
+		if _, err := healthy.Recv(); err != nil {
+			t.Fatalf("healthy listener missed event %d: %v", i, err)
+		}
+	}
+
+	if dropped := atomic.LoadUint64(&stalled.dropped); dropped != calls-2 {
+		t.Fatalf("expected %d drops for the stalled listener, have %d", calls-2, dropped)
+	}
+	if total := srv.DroppedEvents(); total != calls-2 {
+		t.Fatalf("expected %d dropped events in total, have %d", calls-2, total)
+	}
+}
+
+// blockingStatStream holds every Send until release is closed
+type blockingStatStream struct {
+	ctx     context.Context
+	release chan struct{}
+	sent    chan *Stat
+}
+
+func (s *blockingStatStream) Send(stat *Stat) error {
+	<-s.release
+	s.sent <- stat
+	return nil
+}
+
+func (s *blockingStatStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestSlowStatListener(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData)
+	defer stop()
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	// a stat listener with room for one message that never reads
+	busy := &statListener{
+		statCh:  make(chan *statMsg, 1),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	defer close(busy.doneCh)
+	if err := srv.addStatListener(busy); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// there is no stream handler to do it
+	srv.streams.Done()
+
+	const calls = 20
+	for i := 0; i < calls; i++ {
+		callCtx, cancel := context.WithTimeout(getConsumerCtx("biz_user"), 500*time.Millisecond)
+		_, err := biz.Check(callCtx, &Nothing{})
+		cancel()
+		if err != nil {
+			t.Fatalf("call %d held up by the busy stat listener: %v", i, err)
+		}
+	}
+	wait(1)
+
+	if dropped := atomic.LoadUint64(&busy.dropped); dropped != calls-1 {
+		t.Fatalf("expected %d drops for the busy listener, have %d", calls-1, dropped)
+	}
+}
+
+func TestStatDroppedReported(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData, WithListenerBufferSize(5))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	streamCtx, streamCancel := context.WithCancel(context.Background())
+	defer streamCancel()
+
+	stream := &blockingStatStream{
+		ctx:     streamCtx,
+		release: make(chan struct{}),
+		sent:    make(chan *Stat, 10),
+	}
+	go srv.streamStats(stream, 50*time.Millisecond, false, statFilter{}, nil)
+
+	// the first report is stuck in Send, so the stream stops reading
+	wait(10)
+	for i := 0; i < 10; i++ {
+		srv.broadcastStat(&statMsg{consumerName: "biz_user", methodName: "/main.Biz/Check", at: time.Now()})
+	}
+	close(stream.release)
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case stat := <-stream.sent:
+			if stat.Dropped == 0 {
+				continue
+			}
+			if stat.Dropped != 5 {
+				t.Fatalf("expected 5 dropped, have %d", stat.Dropped)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("no report carried the drops")
+		}
+	}
+}
+
+func TestStatSendTimeout(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData, WithStatSendTimeout(100*time.Millisecond))
+	defer stop()
+	defer conn.Close()
+
+	streamCtx, streamCancel := context.WithCancel(context.Background())
+	defer streamCancel()
+
+	stuck := &blockingStatStream{
+		ctx:     streamCtx,
+		release: make(chan struct{}),
+		sent:    make(chan *Stat, 10),
+	}
+	defer close(stuck.release)
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- srv.streamStats(stuck, 50*time.Millisecond, false, statFilter{}, nil)
+	}()
+
+	select {
+	case err := <-done:
+		if code := grpc.Code(err); code != codes.DeadlineExceeded {
+			t.Fatalf("expected DeadlineExceeded, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("expected the stream to end after about 150ms, it took %v", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the stuck stream was not ended")
+	}
+	if n := srv.StatListenerCount(); n != 0 {
+		t.Fatalf("expected the stuck listener to be removed, have %d", n)
+	}
+
+	// the other streams go on as before
+	statCtx, statCancel := context.WithCancel(getConsumerCtx("stat"))
+	defer statCancel()
+	statStream, err := NewAdminClient(conn).Statistics(statCtx, &StatInterval{IntervalMs: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitListeners(t, srv, 0, 1)
+	NewBizClient(conn).Check(getConsumerCtx("biz_user"), &Nothing{})
+
+	stat, err := statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stat.ByMethod["/main.Biz/Check"] != 1 {
+		t.Fatalf("expected the Check call in the stat, have %+v", stat)
+	}
+}
+
+func TestSlowListener(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	// a listener that never reads
+	stuck := &listener{
+		logsCh:  make(chan *logMsg, listenerBufferSize),
+		closeCh: make(chan struct{}, 1),
+		doneCh:  make(chan struct{}),
+	}
+	defer close(stuck.doneCh)
+	if _, err := srv.addListener(stuck); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// there is no stream handler to do it
+	srv.streams.Done()
+
+	logStream, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	calls := listenerBufferSize + 20
+
+	received := make(chan int)
+	go func() {
+		n := 0
+		for n < calls {
+			if _, err := logStream.Recv(); err != nil {
+				break
+			}
+			n++
+		}
+		received <- n
+	}()
+
+	callsDone := make(chan struct{})
+	go func() {
+		for i := 0; i < calls; i++ {
+			biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+		}
+		close(callsDone)
+	}()
+
+	select {
+	case <-callsDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("biz calls are blocked by the stuck listener")
+	}
+
+	select {
+	case n := <-received:
+		if n != calls {
+			t.Fatalf("healthy listener expected %d events, have %d", calls, n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("healthy listener did not get all events")
+	}
+
+	// the Logging call itself was buffered for the stuck one as well
+	if dropped := srv.DroppedEvents(); dropped != uint64(calls+1-listenerBufferSize) {
+		t.Fatalf("expected %d dropped events, have %d", calls+1-listenerBufferSize, dropped)
+	}
+}
+
+func TestNoStdoutByDefault(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("cant create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	ctx, finish := context.WithCancel(context.Background())
+	_, err = startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+
+	conn := getGrpcConn(t)
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	if _, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalSeconds: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+
+	finish()
+	wait(1)
+	conn.Close()
+
+	os.Stdout = stdout
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	if len(out) != 0 {
+		t.Fatalf("expected nothing on stdout, have %q", out)
+	}
+
+	buf := &bytes.Buffer{}
+	ctx, finish = context.WithCancel(context.Background())
+	_, err = startService(ctx, listenAddr, ACLData, WithLogger(log.New(buf, "", 0)))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	finish()
+	wait(1)
+
+	if expected := "starting server at: " + listenAddr + "\n"; buf.String() != expected {
+		t.Fatalf("expected %q logged, have %q", expected, buf.String())
+	}
+}
+
+func TestMicroserviceHandle(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	ms, err := StartMyMicroservice(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+
+	if ms.Addr() != listenAddr {
+		t.Fatalf("expected addr %v, have %v", listenAddr, ms.Addr())
+	}
+
+	select {
+	case <-ms.Ready():
+	case <-time.After(time.Second):
+		t.Fatalf("server is not ready")
+	}
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	finish()
+
+	stopped := make(chan struct{})
+	go func() {
+		ms.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Wait did not return after cancel")
+	}
+
+	// the port is free right after Wait
+	lis, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		t.Fatalf("port is still busy: %v", err)
+	}
+	lis.Close()
+}
+
+func TestMicroserviceEphemeralPort(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	defer finish()
+
+	ms, err := StartMyMicroservice(ctx, "127.0.0.1:0", ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	<-ms.Ready()
+
+	host, port, err := net.SplitHostPort(ms.Addr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if host != "127.0.0.1" || port == "0" {
+		t.Fatalf("expected the assigned port in addr, have %v", ms.Addr())
+	}
+
+	conn, err := grpc.Dial(ms.Addr(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("cant connect to grpc: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := NewBizClient(conn).Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	finish()
+	ms.Wait()
+}
+
+func TestShutdownDuringUnaryCall(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+
+	callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("consumer", "biz_admin"))
+
+	slow := func(ctx context.Context, req interface{}) (interface{}, error) {
+		time.Sleep(200 * time.Millisecond)
+		return &Nothing{}, nil
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := srv.unaryInterceptor(callCtx, &Nothing{}, &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Test"}, slow)
+		result <- err
+	}()
+	wait(5)
+
+	finish()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the call hung reporting its stats after shutdown")
+	}
+	(&Microservice{srv}).Wait()
+}
+
+func TestUnaryCallAfterShutdown(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+
+	finish()
+	(&Microservice{srv}).Wait()
+
+	// the senders are gone, nothing reads incomingLogsCh anymore
+	callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("consumer", "biz_user"))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &Nothing{}, nil
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := srv.unaryInterceptor(callCtx, &Nothing{}, &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Check"}, handler)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the call hung on the log fan-out after shutdown")
+	}
+}
+
+func TestStreamCallAfterShutdown(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+
+	finish()
+	(&Microservice{srv}).Wait()
+
+	noop := func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	}
+
+	for _, call := range []struct {
+		consumer string
+		method   string
+	}{
+		{"logger", "/main.Admin/Logging"},
+		{"stat", "/main.Admin/Statistics"},
+	} {
+		callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("consumer", call.consumer))
+
+		result := make(chan error, 1)
+		go func(method string) {
+			result <- srv.streamInterceptor(nil, &serverStreamWithContext{ctx: callCtx},
+				&grpc.StreamServerInfo{FullMethod: method}, noop)
+		}(call.method)
+
+		select {
+		case <-result:
+		case <-time.After(time.Second):
+			t.Fatalf("%v hung reporting the call after shutdown", call.method)
+		}
+	}
+}
+
+func TestStreamsDrainBeforeStop(t *testing.T) {
+	var srv *service
+	drained := make(chan bool, 1)
+
+	onStopped := func(err error) {
+		done := make(chan struct{})
+		go func() {
+			srv.streams.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			drained <- true
+		case <-time.After(10 * time.Millisecond):
+			drained <- false
+		}
+	}
+
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData, WithLifecycle(nil, onStopped))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	logStream, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	statStream, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	finish()
+	(&Microservice{srv}).Wait()
+
+	if !<-drained {
+		t.Fatalf("admin streams were still running when the server stopped")
+	}
+
+	// the handlers returned nil, which the client sees as a clean end. The
+	// logging stream gets the shutdown sentinel first
+	if evt, err := logStream.Recv(); err != nil || evt.Method != shutdownEventMethod {
+		t.Fatalf("expected the shutdown sentinel, got %v %v", evt, err)
+	}
+	if _, err := logStream.Recv(); err != io.EOF {
+		t.Fatalf("expected logging stream to end cleanly, got %v", err)
+	}
+	if _, err := statStream.Recv(); err != io.EOF {
+		t.Fatalf("expected statistics stream to end cleanly, got %v", err)
+	}
+}
+
+func TestShutdownBlockedLogging(t *testing.T) {
+	slowpoke := strings.Repeat("x", 2048)
+	acl := `{
+	"logger": ["/main.Admin/Logging"],
+	"` + slowpoke + `": ["/main.Biz/Check"]
+}`
+
+	ctx, finish := context.WithCancel(context.Background())
+	defer finish()
+	srv, err := startService(ctx, "127.0.0.1:0", acl, WithListenerBufferSize(2),
+		WithLogDelivery(BlockOnFull, 0), WithShutdownTimeout(300*time.Millisecond))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	<-srv.serving
+
+	// a fixed window, so the stream that is never read fills up
+	conn, err := grpc.Dial(srv.addr, grpc.WithInsecure(),
+		grpc.WithInitialWindowSize(64*1024), grpc.WithInitialConnWindowSize(64*1024))
+	if err != nil {
+		t.Fatalf("cant connect to grpc: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := NewAdminClient(conn).Logging(getConsumerCtx("logger"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitListeners(t, srv, 1, 0)
+
+	// calls go on until the sender is stuck on the stream
+	biz := NewBizClient(conn)
+	for i := 0; ; i++ {
+		if i == 300 {
+			t.Fatalf("expected a call to be held up")
+		}
+		callCtx, cancel := context.WithTimeout(getConsumerCtx(slowpoke), 200*time.Millisecond)
+		_, err := biz.Check(callCtx, &Nothing{})
+		cancel()
+		if grpc.Code(err) == codes.DeadlineExceeded {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	finish()
+	select {
+	case <-srv.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("shutdown hung on a logging stream that is not read")
+	}
+}
+
+func TestGracefulStop(t *testing.T) {
+	acl := `{
+	"drainer": ["/main.Admin/DrainEvents"]
+}`
+
+	// DrainEvents blocked on the history lock stands in for a slow call
+	startSlowCall := func(t *testing.T, options ...Option) (*service, func(), chan error) {
+		options = append(options, WithLogHistory(10))
+		srv, conn, stop := startTestServer(t, acl, options...)
+		adm := NewAdminClient(conn)
+
+		srv.historyMu.Lock()
+
+		result := make(chan error, 1)
+		go func() {
+			defer conn.Close()
+			stream, err := adm.DrainEvents(getConsumerCtx("drainer"), &Nothing{})
+			if err != nil {
+				result <- err
+				return
+			}
+			_, err = stream.Recv()
+			result <- err
+		}()
+		wait(1)
+
+		return srv, stop, result
+	}
+
+	t.Run("in-flight call completes", func(t *testing.T) {
+		srv, stop, result := startSlowCall(t)
+
+		go stop()
+		wait(5)
+		srv.historyMu.Unlock()
+
+		if err := <-result; err != io.EOF {
+			t.Fatalf("expected the call to complete, got %v", err)
+		}
+		stop()
+	})
+
+	t.Run("cut off after timeout", func(t *testing.T) {
+		srv, stop, result := startSlowCall(t, WithShutdownTimeout(20*time.Millisecond))
+
+		stop()
+
+		if err := <-result; grpc.Code(err) != codes.Unavailable {
+			t.Fatalf("expected the call to be cut off, got %v", err)
+		}
+		srv.historyMu.Unlock()
+	})
+}
+
+func TestShutdownSentinel(t *testing.T) {
+	for name, mode := range map[string]DeliveryMode{"drop": DropOnFull, "close": CloseOnFull} {
+		t.Run(name, func(t *testing.T) {
+			srv, conn, stop := startTestServer(t, ACLData, WithLogDelivery(mode, time.Second))
+			defer stop()
+			defer conn.Close()
+
+			stream, err := NewAdminClient(conn).Logging(getConsumerCtx("logger"), &Nothing{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			waitListeners(t, srv, 1, 0)
+
+			NewBizClient(conn).Check(getConsumerCtx("biz_user"), &Nothing{})
+			// the events wait on the connection until they are read
+			stop()
+
+			methods := []string{}
+			var last *Event
+			for {
+				evt, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("expected the stream to end cleanly, got %v", err)
+				}
+				methods = append(methods, evt.Method)
+				last = evt
+			}
+
+			expected := []string{"/main.Biz/Check", shutdownEventMethod}
+			if !reflect.DeepEqual(methods, expected) {
+				t.Fatalf("expected the call and then the sentinel, have %v", methods)
+			}
+			if last.Consumer != "" || last.Host != srv.addr || last.Timestamp == 0 {
+				t.Fatalf("sentinel is not what is documented: %+v", last)
+			}
+		})
+	}
+}
+
+func TestACLMatch(t *testing.T) {
+	srv := &service{
+		m:          &sync.RWMutex{},
+		tempGrants: make(map[string][]tempGrant),
+		aclStorage: map[string][]string{
+			"exact":   {"/main.Biz/Check"},
+			"service": {"/main.Admin/*"},
+			"global":  {"*"},
+			"denied":  {"/main.Biz/*", "!/main.Biz/Test"},
+			"nothing": {"*", "!/main.*"},
+		},
+	}
+
+	cases := []struct {
+		consumer string
+		method   string
+		code     codes.Code
+	}{
+		{"exact", "/main.Biz/Check", codes.OK},
+		{"exact", "/main.Biz/Add", codes.PermissionDenied},
+		{"exact", "/main.Biz/CheckMore", codes.PermissionDenied},
+		{"service", "/main.Admin/Logging", codes.OK},
+		{"service", "/main.Admin/Statistics", codes.OK},
+		{"service", "/main.Biz/Check", codes.PermissionDenied},
+		{"service", "/main.AdminX/Logging", codes.PermissionDenied},
+		{"global", "/main.Biz/Test", codes.OK},
+		{"global", "/main.Admin/Logging", codes.OK},
+		{"unknown", "/main.Biz/Check", codes.Unauthenticated},
+		{"denied", "/main.Biz/Check", codes.OK},
+		{"denied", "/main.Biz/Test", codes.PermissionDenied},
+		{"nothing", "/main.Biz/Check", codes.PermissionDenied},
+		{"nothing", "/main.Admin/Logging", codes.PermissionDenied},
+	}
+
+	for _, c := range cases {
+		err := srv.checkBizPermission(c.consumer, c.method)
+		if code := grpc.Code(err); code != c.code {
+			t.Errorf("%s calling %s: expected %v, got %v", c.consumer, c.method, c.code, err)
+		}
+	}
+}
+
+func TestACLCaseInsensitive(t *testing.T) {
+	newSrv := func(cfg Config) *service {
+		return &service{
+			cfg:        cfg,
+			m:          &sync.RWMutex{},
+			tempGrants: make(map[string][]tempGrant),
+			aclStorage: map[string][]string{
+				"exact":   {"/main.Biz/Check"},
+				"service": {"/main.Admin/*"},
+				"pkg":     {"/main.*", "!/main.Biz/Test"},
+			},
+		}
+	}
+
+	cases := []struct {
+		consumer    string
+		method      string
+		sensitive   codes.Code
+		insensitive codes.Code
+	}{
+		{"exact", "/main.Biz/Check", codes.OK, codes.OK},
+		{"exact", "/main.biz/CHECK", codes.PermissionDenied, codes.OK},
+		{"exact", "/main.Biz/Add", codes.PermissionDenied, codes.PermissionDenied},
+		{"service", "/MAIN.ADMIN/Logging", codes.PermissionDenied, codes.OK},
+		{"service", "/main.biz/Logging", codes.PermissionDenied, codes.PermissionDenied},
+		{"pkg", "/Main.Biz/Add", codes.PermissionDenied, codes.OK},
+		// the deny is matched the same way, case sensitive it misses
+		{"pkg", "/main.Biz/Test", codes.PermissionDenied, codes.PermissionDenied},
+		{"pkg", "/main.BIZ/test", codes.OK, codes.PermissionDenied},
+	}
+
+	sensitive := newSrv(Config{})
+	insensitive := newSrv(Config{CaseInsensitiveACL: true})
+
+	for _, c := range cases {
+		err := sensitive.checkBizPermission(c.consumer, c.method)
+		if code := grpc.Code(err); code != c.sensitive {
+			t.Errorf("case sensitive, %s calling %s: expected %v, got %v", c.consumer, c.method, c.sensitive, err)
+		}
+
+		err = insensitive.checkBizPermission(c.consumer, c.method)
+		if code := grpc.Code(err); code != c.insensitive {
+			t.Errorf("case insensitive, %s calling %s: expected %v, got %v", c.consumer, c.method, c.insensitive, err)
+		}
+	}
+
+	insensitive.GrantTemporary("exact", "/main.Biz/Add", time.Minute)
+	if err := insensitive.checkBizPermission("exact", "/MAIN.biz/add"); err != nil {
+		t.Errorf("expected the temporary grant to match ignoring case, got %v", err)
+	}
+}
+
+func TestAdminStreamACL(t *testing.T) {
+	acl := `{
+	"logger_only": ["/main.Admin/Logging"],
+	"stats_only":  ["/main.Admin/Statistics"],
+	"admin":       ["/main.Admin/*"]
+}`
+	srv, conn, stop := startTestServer(t, acl)
+	defer stop()
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	// each opens a stream and returns the error of its first Recv, the
+	// stream is cancelled right after
+	type open func(ctx context.Context) error
+	streams := map[string]open{
+		"/main.Admin/Logging": func(ctx context.Context) error {
+			stream, err := adm.Logging(ctx, &Nothing{})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		},
+		"/main.Admin/Statistics": func(ctx context.Context) error {
+			stream, err := adm.Statistics(ctx, &StatInterval{IntervalMs: 10})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		},
+		"/main.Admin/StatisticsInteractive": func(ctx context.Context) error {
+			stream, err := adm.StatisticsInteractive(ctx)
+			if err != nil {
+				return err
+			}
+			stream.Send(&StatInterval{IntervalMs: 10})
+			_, err = stream.Recv()
+			return err
+		},
+		"/main.Admin/AuditAccess": func(ctx context.Context) error {
+			stream, err := adm.AuditAccess(ctx, &Nothing{})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		},
+	}
+
+	cases := []struct {
+		consumer string
+		method   string
+		allowed  bool
+	}{
+		{"logger_only", "/main.Admin/Logging", true},
+		{"logger_only", "/main.Admin/Statistics", false},
+		{"logger_only", "/main.Admin/StatisticsInteractive", false},
+		{"logger_only", "/main.Admin/AuditAccess", false},
+		{"stats_only", "/main.Admin/Statistics", true},
+		{"stats_only", "/main.Admin/Logging", false},
+		// an exact entry is not a prefix
+		{"stats_only", "/main.Admin/StatisticsInteractive", false},
+		{"admin", "/main.Admin/StatisticsInteractive", true},
+		{"admin", "/main.Admin/AuditAccess", true},
+	}
+
+	for _, c := range cases {
+		streamCtx, cancel := context.WithTimeout(getConsumerCtx(c.consumer), 100*time.Millisecond)
+		err := streams[c.method](streamCtx)
+		cancel()
+
+		code := grpc.Code(err)
+		if c.allowed && code == codes.PermissionDenied {
+			t.Errorf("%s opening %s: expected to be allowed, got %v", c.consumer, c.method, err)
+		}
+		if !c.allowed && code != codes.PermissionDenied {
+			t.Errorf("%s opening %s: expected PermissionDenied, got %v", c.consumer, c.method, err)
+		}
+	}
+	wait(1)
+
+	// nothing is left subscribed, allowed or not
+	srv.m.RLock()
+	listeners := len(srv.listeners) + len(srv.statListeners) + len(srv.auditListeners)
+	srv.m.RUnlock()
+	if listeners != 0 {
+		t.Fatalf("expected no listeners left, have %d", listeners)
+	}
+}
+
+func TestACLAnyConsumer(t *testing.T) {
+	srv := &service{
+		m:          &sync.RWMutex{},
+		tempGrants: make(map[string][]tempGrant),
+		aclStorage: map[string][]string{
+			"*":       {"/main.Biz/Check"},
+			"adder":   {"/main.Biz/Add"},
+			"checker": {},
+			"limited": {"!/main.Biz/Check"},
+		},
+	}
+
+	cases := []struct {
+		consumer string
+		method   string
+		code     codes.Code
+	}{
+		// only the wildcard entry grants it
+		{"checker", "/main.Biz/Check", codes.OK},
+		{"checker", "/main.Biz/Add", codes.PermissionDenied},
+		{"stranger", "/main.Biz/Check", codes.OK},
+		{"stranger", "/main.Biz/Add", codes.Unauthenticated},
+		// both entries combine
+		{"adder", "/main.Biz/Check", codes.OK},
+		{"adder", "/main.Biz/Add", codes.OK},
+		{"adder", "/main.Biz/Test", codes.PermissionDenied},
+		// an own deny still wins
+		{"limited", "/main.Biz/Check", codes.PermissionDenied},
+	}
+
+	for _, c := range cases {
+		err := srv.checkBizPermission(c.consumer, c.method)
+		if code := grpc.Code(err); code != c.code {
+			t.Errorf("%s calling %s: expected %v, got %v", c.consumer, c.method, c.code, err)
+		}
+	}
+}
+
+func TestPermissionDeniedDetails(t *testing.T) {
+	biz, _, cleanup := startTestService(t, ACLData)
+	defer cleanup()
+
+	_, err := biz.Test(getConsumerCtx("biz_user"), &Nothing{})
+	st, _ := status.FromError(err)
+	if st.Code() != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied code, got %v", err)
+	}
+
+	details := st.Details()
+	if len(details) != 1 {
+		t.Fatalf("expected one detail, have %+v", details)
+	}
+	denied, ok := details[0].(*AccessDenied)
+	if !ok {
+		t.Fatalf("expected *AccessDenied, have %T", details[0])
+	}
+	expected := &AccessDenied{Consumer: "biz_user", Method: "/main.Biz/Test"}
+	if !reflect.DeepEqual(denied, expected) {
+		t.Fatalf("details dont match\nhave %+v\nwant %+v", denied, expected)
+	}
+
+	// an unknown consumer learns nothing more than that
+	_, err = biz.Check(getConsumerCtx("unknown"), &Nothing{})
+	st, _ = status.FromError(err)
+	if st.Code() != codes.Unauthenticated || len(st.Details()) != 0 {
+		t.Fatalf("expected Unauthenticated without details, got %v %+v", err, st.Details())
+	}
+}
+
+func TestACLDenyRules(t *testing.T) {
+	acl := `{"biz_user": ["/main.Biz/*", "!/main.Biz/Test"]}`
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, acl)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = biz.Test(getConsumerCtx("biz_user"), &Nothing{})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected the deny rule to win over the wildcard, got %v", err)
+	}
+}
+
+func TestParseACLMalformed(t *testing.T) {
+	valid := []string{
+		"*", "/main.*", "/main.Biz/*", "/main.Biz/Check", "!/main.Biz/Test", "!*",
+	}
+	for _, entry := range valid {
+		acl := fmt.Sprintf(`{"biz_user": [%q]}`, entry)
+		if _, err := parseACL(acl); err != nil {
+			t.Errorf("unexpected error for %q: %v", entry, err)
+		}
+	}
+
+	malformed := []string{
+		"", "Check", "main.Biz/Check", "/main.Biz", "/main.Biz/", "//Check",
+		"/main.Biz/Check/More", "/main.*/Check", "/main.Biz/Ch*", "!", "/*",
+	}
+	for _, entry := range malformed {
+		acl := fmt.Sprintf(`{"biz_user": [%q]}`, entry)
+		_, err := parseACL(acl)
+		if err == nil {
+			t.Errorf("expected %q to be rejected", entry)
+			continue
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("malformed ACL entry %q", entry)) {
+			t.Errorf("error for %q does not name the entry: %v", entry, err)
+		}
+	}
+}
+
+func TestParseACLValueShapes(t *testing.T) {
+	got, err := parseACL(`{"biz_user": null, "biz_admin": [], "logger": ["/main.Admin/Logging"]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{
+		"biz_user":  {},
+		"biz_admin": {},
+		"logger":    {"/main.Admin/Logging"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("ACL parsed wrong\ngot\t%v\nexpected\t%v", got, expected)
+	}
+
+	bad := []string{
+		`{"logger": ["/main.Admin/Logging"], "biz_user": "/main.Biz/Check"}`,
+		`{"logger": ["/main.Admin/Logging"], "biz_user": [1]}`,
+		`{"logger": ["/main.Admin/Logging"], "biz_user": {"method": "/main.Biz/Check"}}`,
+	}
+	for _, acl := range bad {
+		_, err := parseACL(acl)
+		if err == nil || !strings.Contains(err.Error(), `consumer "biz_user": want a list of methods`) {
+			t.Errorf("expected an error naming biz_user for %s, got %v", acl, err)
+		}
+	}
+}
+
+func TestACLNullConsumer(t *testing.T) {
+	biz, _, cleanup := startTestService(t, `{"biz_user": null, "biz_admin": ["/main.Biz/*"]}`)
+	defer cleanup()
+
+	_, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected a null consumer to be denied, got %v", err)
+	}
+	if _, err := biz.Check(getConsumerCtx("biz_admin"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestACLConsumerGlob(t *testing.T) {
+	acl := `{
+	"svc-payments-*":  ["/main.Biz/Check"],
+	"svc-payments-1?": ["/main.Biz/Add"],
+	"svc-payments-7":  ["/main.Biz/Test"],
+	"*":               ["/main.Admin/Health"]
+}`
+	biz, adm, cleanup := startTestService(t, acl)
+	defer cleanup()
+
+	tests := []struct {
+		consumer string
+		method   string
+		code     codes.Code
+	}{
+		{"svc-payments-1", "/main.Biz/Check", codes.OK},
+		{"svc-payments-2", "/main.Biz/Check", codes.OK},
+		{"svc-payments-2", "/main.Biz/Add", codes.PermissionDenied},
+		// the longer pattern is more specific and wins
+		{"svc-payments-12", "/main.Biz/Add", codes.OK},
+		{"svc-payments-12", "/main.Biz/Check", codes.PermissionDenied},
+		// an exact key wins over the patterns
+		{"svc-payments-7", "/main.Biz/Test", codes.OK},
+		{"svc-payments-7", "/main.Biz/Check", codes.PermissionDenied},
+		{"svc-orders-1", "/main.Biz/Check", codes.Unauthenticated},
+	}
+	for _, tc := range tests {
+		var err error
+		ctx := getConsumerCtx(tc.consumer)
+		switch tc.method {
+		case "/main.Biz/Check":
+			_, err = biz.Check(ctx, &Nothing{})
+		case "/main.Biz/Add":
+			_, err = biz.Add(ctx, &Nothing{})
+		case "/main.Biz/Test":
+			_, err = biz.Test(ctx, &Nothing{})
+		}
+		if code := grpc.Code(err); code != tc.code {
+			t.Errorf("%s calling %s: expected %v, got %v", tc.consumer, tc.method, tc.code, err)
+		}
+	}
+
+	// the entries for everyone still apply to a consumer matched by a pattern
+	if _, err := adm.Health(getConsumerCtx("svc-payments-3"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := parseACL(`{"svc-[payments": ["/main.Biz/Check"]}`); err == nil {
+		t.Fatalf("expected a malformed pattern to be rejected")
+	}
+}
+
+func TestParseACLRoles(t *testing.T) {
+	acl := `{
+		"roles": {
+			"reader": ["/main.Biz/Check"],
+			"writer": ["/main.Biz/Add", "!/main.Biz/Test"],
+			"auditor": ["/main.Admin/Logging"]
+		},
+		"consumers": {
+			"biz_user": ["reader", "writer"],
+			"logger": ["auditor"],
+			"nobody": []
+		}
+	}`
+	got, err := parseACL(acl)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{
+		"biz_user": {"/main.Biz/Check", "/main.Biz/Add", "!/main.Biz/Test"},
+		"logger":   {"/main.Admin/Logging"},
+		"nobody":   {},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("roles resolved wrong\ngot\t%v\nexpected\t%v", got, expected)
+	}
+
+	_, err = parseACL(`{"roles": {"reader": ["/main.Biz/Check"]}, "consumers": {"biz_user": ["writer"]}}`)
+	if err == nil || !strings.Contains(err.Error(), `consumer "biz_user": unknown role "writer"`) {
+		t.Fatalf("expected unknown role error, got %v", err)
+	}
+
+	_, err = parseACL(`{"roles": {"reader": ["main.Biz/Check"]}, "consumers": {}}`)
+	if err == nil || !strings.Contains(err.Error(), `role "reader": malformed ACL entry`) {
+		t.Fatalf("expected malformed entry error, got %v", err)
+	}
+
+	_, err = parseACL(`{"roles": {}, "consumers": {}, "biz_user": ["/main.Biz/Check"]}`)
+	if err == nil {
+		t.Fatalf("expected mixing the schemas to be rejected")
+	}
+
+	// the flat format still works, even for a consumer named "roles"
+	got, err = parseACL(`{"roles": ["/main.Biz/Check"], "biz_user": ["/main.Biz/*"]}`)
+	if err != nil {
+		t.Fatalf("unexpected error for the flat format: %v", err)
+	}
+	expected = map[string][]string{
+		"roles":    {"/main.Biz/Check"},
+		"biz_user": {"/main.Biz/*"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("flat ACL parsed wrong\ngot\t%v\nexpected\t%v", got, expected)
+	}
+}
+
+func TestACLRolesService(t *testing.T) {
+	acl := `{
+		"roles": {"checker": ["/main.Biz/Check"]},
+		"consumers": {"biz_user": ["checker"]}
+	}`
+	biz, _, cleanup := startTestService(t, acl)
+	defer cleanup()
+
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("expected Check to be allowed through the role, got %v", err)
+	}
+	_, err := biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected Add to be denied, got %v", err)
+	}
+}
+
+func TestReloadACL(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	if _, err := biz.Add(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = srv.ReloadACL(`{"biz_user": ["/main.Biz/Check"]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	if err == nil || grpc.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected Add to be revoked, got %v", err)
+	}
+
+	// a broken update keeps the current rules
+	if err := srv.ReloadACL("{.;"); err == nil {
+		t.Fatalf("expected error on bad acl json, have nil")
+	}
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("rules lost after a bad reload: %v", err)
+	}
+}
+
+func TestAuthCache(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData, WithAuthCache(time.Minute, 16))
+	defer stop()
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	if _, err := biz.Add(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// rules changed behind the cache's back, the connection keeps its answer
+	srv.m.Lock()
+	srv.aclStorage = map[string][]string{"biz_user": {"/main.Biz/Check"}}
+	srv.m.Unlock()
+	if _, err := biz.Add(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("expected the cached permission to be used, got %v", err)
+	}
+
+	// a new connection has its own cache
+	conn2, err := grpc.Dial(srv.addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("cant connect to grpc: %v", err)
+	}
+	defer conn2.Close()
+	_, err = NewBizClient(conn2).Add(getConsumerCtx("biz_user"), &Nothing{})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected a new connection to check the ACL, got %v", err)
+	}
+
+	if err := srv.ReloadACL(`{"biz_user": ["/main.Biz/Check"]}`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected the reload to flush the cache, got %v", err)
+	}
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestAuthCacheSkipsTemporaryGrants(t *testing.T) {
+	srv := &service{
+		m:          &sync.RWMutex{},
+		aclStorage: map[string][]string{"biz_user": {"/main.Biz/Check"}},
+		tempGrants: make(map[string][]tempGrant),
+	}
+	cache := newAuthCache(time.Minute, 16)
+	ctx := context.WithValue(context.Background(), authCacheCtxKey{}, cache)
+
+	srv.GrantTemporary("biz_user", "/main.Biz/Add", 50*time.Millisecond)
+	if err := srv.checkBizPermissionCached(ctx, "biz_user", "/main.Biz/Add"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	err := srv.checkBizPermissionCached(ctx, "biz_user", "/main.Biz/Add")
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected the grant to expire despite the cache, got %v", err)
+	}
+}
+
+func TestAuthCacheBounded(t *testing.T) {
+	cache := newAuthCache(time.Minute, 4)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		cache.store(authKey{consumer: "biz_user", method: fmt.Sprintf("/main.Biz/M%d", i)}, 0, now)
+		if len(cache.expires) > 4 {
+			t.Fatalf("cache grew to %d entries over its size of 4", len(cache.expires))
+		}
+	}
+	if !cache.allowed(authKey{consumer: "biz_user", method: "/main.Biz/M9"}, 0, now) {
+		t.Fatalf("expected the last stored entry to be kept")
+	}
+}
+
+func BenchmarkCheckBizPermission(b *testing.B) {
+	aclParsed, err := parseACL(ACLData)
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	srv := &service{
+		m:          &sync.RWMutex{},
+		aclStorage: aclParsed,
+		tempGrants: make(map[string][]tempGrant),
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		ctx := context.Background()
+		for i := 0; i < b.N; i++ {
+			srv.checkBizPermissionCached(ctx, "biz_user", "/main.Biz/Add")
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		ctx := context.WithValue(context.Background(), authCacheCtxKey{}, newAuthCache(time.Minute, 0))
+		for i := 0; i < b.N; i++ {
+			srv.checkBizPermissionCached(ctx, "biz_user", "/main.Biz/Add")
+		}
+	})
+}
+
+func TestACLFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "acl.json")
+
+	if err := ioutil.WriteFile(path, []byte(ACLData), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, finish := context.WithCancel(context.Background())
+	_, err = StartMyMicroserviceFromACLFile(ctx, listenAddr, path, WithACLReloadInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	if _, err := biz.Add(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the mtime is moved on explicitly, a rewrite within the same tick of
+	// a coarse clock would go unnoticed
+	rewrite := func(acl string) {
+		if err := ioutil.WriteFile(path, []byte(acl), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mtime := time.Now().Add(time.Duration(len(acl)) * time.Second)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		wait(10)
+	}
+
+	rewrite(`{"biz_user": ["/main.Biz/Check"]}`)
+	_, err = biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected Add to be revoked after the file changed, got %v", err)
+	}
+
+	// a broken file keeps the current rules
+	rewrite("{.;")
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("rules lost after a bad file: %v", err)
+	}
+}
+
+func TestACLFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, finish := context.WithCancel(context.Background())
+	defer finish()
+
+	_, err = StartMyMicroserviceFromACLFile(ctx, listenAddr, filepath.Join(dir, "missing.json"))
+	if err == nil || !strings.Contains(err.Error(), "can not read the ACL file") {
+		t.Fatalf("expected an error on a missing file, have %v", err)
+	}
+
+	path := filepath.Join(dir, "bad.json")
+	if err := ioutil.WriteFile(path, []byte("{.;"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = StartMyMicroserviceFromACLFile(ctx, listenAddr, path)
+	if err == nil || !strings.Contains(err.Error(), path) {
+		t.Fatalf("expected an error naming the bad file, have %v", err)
+	}
+}
+
+func TestLatencies(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	slow := func(ctx context.Context, req interface{}) (interface{}, error) {
+		time.Sleep(50 * time.Millisecond)
+		return &Nothing{}, nil
+	}
+	fast := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &Nothing{}, nil
+	}
+
+	callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("consumer", "biz_admin"))
+	srv.unaryInterceptor(callCtx, &Nothing{}, &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Test"}, slow)
+	srv.unaryInterceptor(callCtx, &Nothing{}, &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Check"}, fast)
+	wait(1)
+
+	latencies := srv.Latencies()
+
+	l, ok := latencies["/main.Biz/Test"]
+	if !ok || l.Count != 1 {
+		t.Fatalf("expected one timed call for Test, have %+v", latencies)
+	}
+	if l.Mean < 50*time.Millisecond || l.P50 < 50*time.Millisecond || l.P95 < 50*time.Millisecond {
+		t.Fatalf("slow handler is not reflected in latency: %+v", l)
+	}
+	if l.P95 > l.Max {
+		t.Fatalf("percentile above max: %+v", l)
+	}
+
+	if l := latencies["/main.Biz/Check"]; l.Count != 1 || l.Max >= 50*time.Millisecond {
+		t.Fatalf("unexpected latency for Check: %+v", l)
+	}
+}
+
+func TestStatsAccumulatorConcurrent(t *testing.T) {
+	const (
+		workers = 8
+		calls   = 1000
+	)
+
+	acc := newStatsAccumulator(0)
+
+	var total, failed uint64
+	collect := func(stat *Stat) {
+		for _, n := range stat.ByMethod {
+			total += n
+		}
+		for _, n := range stat.ErrorsByMethod {
+			failed += n
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			consumer := fmt.Sprintf("consumer_%d", i)
+			for j := 0; j < calls; j++ {
+				code := codes.OK
+				if j%10 == 0 {
+					code = codes.Internal
+				}
+				acc.Add(consumer, "/main.Biz/Check", code)
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+snapshots:
+	for {
+		select {
+		case <-done:
+			break snapshots
+		default:
+			collect(acc.SnapshotAndReset())
+		}
+	}
+	collect(acc.SnapshotAndReset())
+
+	if total != workers*calls {
+		t.Fatalf("expected %d calls over all snapshots, have %d", workers*calls, total)
+	}
+	if failed != workers*calls/10 {
+		t.Fatalf("expected %d failed calls over all snapshots, have %d", workers*calls/10, failed)
+	}
+}
+
+func TestLatencyHistogramPercentile(t *testing.T) {
+	h := newLatencyHistogram()
+	for i := 0; i < 90; i++ {
+		h.add(3 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.add(300 * time.Millisecond)
+	}
+
+	if p := h.percentile(0.5); p != 5*time.Millisecond {
+		t.Fatalf("expected p50 of 5ms, have %v", p)
+	}
+	if p := h.percentile(0.95); p != 300*time.Millisecond {
+		t.Fatalf("expected p95 capped at max 300ms, have %v", p)
+	}
+}
+
+func TestStatTimestamp(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	start := time.Now().Unix()
+	statStream, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stat1, err := statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stat2, err := statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stat1.Timestamp < start || stat1.Timestamp > time.Now().Unix() {
+		t.Fatalf("timestamp %v is not wall clock time", stat1.Timestamp)
+	}
+	if diff := stat2.Timestamp - stat1.Timestamp; diff < 1 || diff > 2 {
+		t.Fatalf("expected timestamps about 1s apart, have %v and %v", stat1.Timestamp, stat2.Timestamp)
+	}
+}
+
+func TestStatIntervalMs(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	statCtx, statCancel := context.WithTimeout(getConsumerCtx("stat"), time.Second)
+	defer statCancel()
+	statStream, err := adm.Statistics(statCtx, &StatInterval{IntervalSeconds: 5, IntervalMs: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := statStream.Recv(); err != nil {
+			t.Fatalf("expected a stat every 100ms, got %v", err)
+		}
+	}
+}
+
+func TestStatSendInitial(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	statCtx, statCancel := context.WithCancel(getConsumerCtx("stat"))
+	defer statCancel()
+	started := time.Now()
+	statStream, err := adm.Statistics(statCtx, &StatInterval{IntervalMs: 500, SendInitial: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stat, err := statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 250*time.Millisecond {
+		t.Fatalf("expected the first stat right away, it took %v", elapsed)
+	}
+	if stat.Timestamp == 0 || len(stat.ByMethod) != 0 || len(stat.ByConsumer) != 0 {
+		t.Fatalf("expected an empty stat with a timestamp, have %+v", stat)
+	}
+
+	// the interval goes on as usual
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stat, err = statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stat.ByMethod["/main.Biz/Check"] != 1 {
+		t.Fatalf("expected the Check call in the next stat, have %+v", stat)
+	}
+
+	// without the flag nothing comes before the interval passes
+	quietCtx, quietCancel := context.WithTimeout(getConsumerCtx("stat"), 200*time.Millisecond)
+	defer quietCancel()
+	quietStream, err := adm.Statistics(quietCtx, &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := quietStream.Recv(); grpc.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected no stat before the interval, got %v", err)
+	}
+}
+
+func TestStatGauges(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData)
+	defer stop()
+	defer conn.Close()
+
+	statConn, err := grpc.Dial(srv.addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("cant connect to grpc: %v", err)
+	}
+	defer statConn.Close()
+
+	logCtx, logCancel := context.WithCancel(getConsumerCtx("logger"))
+	defer logCancel()
+	for i := 0; i < 2; i++ {
+		if _, err := NewAdminClient(conn).Logging(logCtx, &Nothing{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	waitListeners(t, srv, 2, 0)
+
+	statCtx, statCancel := context.WithCancel(getConsumerCtx("stat"))
+	defer statCancel()
+	statStream, err := NewAdminClient(statConn).Statistics(statCtx, &StatInterval{IntervalMs: 100, SendInitial: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stat, err := statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stat.ActiveLoggingStreams != 2 || stat.ActiveStatStreams != 1 || stat.ActiveConnections != 2 {
+		t.Fatalf("expected 2 logging streams, 1 stat stream and 2 connections, have %d, %d and %d",
+			stat.ActiveLoggingStreams, stat.ActiveStatStreams, stat.ActiveConnections)
+	}
+
+	// the gauges follow the streams going away
+	logCancel()
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		stat, err := statStream.Recv()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stat.ActiveLoggingStreams == 0 && stat.ActiveStatStreams == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the logging streams gone from the report, have %+v", stat)
+		}
+	}
+}
+
+func TestStatFilter(t *testing.T) {
+	biz, adm, cleanup := startTestService(t, ACLData)
+	defer cleanup()
+
+	statCtx, statCancel := context.WithCancel(getConsumerCtx("stat"))
+	defer statCancel()
+
+	byConsumer, err := adm.Statistics(statCtx, &StatInterval{IntervalMs: 500, SendInitial: true, Consumer: "biz_user"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	byMethod, err := adm.Statistics(statCtx, &StatInterval{IntervalMs: 500, SendInitial: true, Method: "/main.Biz/Check"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the initial stats mean both streams count calls now
+	for _, stream := range []Admin_StatisticsClient{byConsumer, byMethod} {
+		if _, err := stream.Recv(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Check(getConsumerCtx("biz_admin"), &Nothing{})
+	biz.Test(getConsumerCtx("biz_admin"), &Nothing{})
+
+	stat, err := byConsumer.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedMethods := map[string]uint64{"/main.Biz/Check": 1, "/main.Biz/Add": 1}
+	expectedConsumers := map[string]uint64{"biz_user": 2}
+	if !reflect.DeepEqual(stat.ByMethod, expectedMethods) || !reflect.DeepEqual(stat.ByConsumer, expectedConsumers) {
+		t.Fatalf("consumer filter let through other calls: %+v", stat)
+	}
+	if !reflect.DeepEqual(stat.LifetimeByConsumer, expectedConsumers) {
+		t.Fatalf("expected lifetime totals of biz_user only, have %v", stat.LifetimeByConsumer)
+	}
+
+	stat, err = byMethod.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedMethods = map[string]uint64{"/main.Biz/Check": 2}
+	expectedConsumers = map[string]uint64{"biz_user": 1, "biz_admin": 1}
+	if !reflect.DeepEqual(stat.ByMethod, expectedMethods) || !reflect.DeepEqual(stat.ByConsumer, expectedConsumers) {
+		t.Fatalf("method filter let through other calls: %+v", stat)
+	}
+}
+
+func TestLoggingFilter(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData)
+	defer stop()
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	logCtx, logCancel := context.WithCancel(getConsumerCtx("logger"))
+	defer logCancel()
+
+	filtered, err := adm.Logging(logCtx, &Nothing{Consumer: "biz_user", MethodPrefix: "/main.Biz/C"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitListeners(t, srv, 1, 0)
+	all, err := adm.Logging(logCtx, &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitListeners(t, srv, 2, 0)
+
+	biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Check(getConsumerCtx("biz_admin"), &Nothing{})
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Add(getConsumerCtx("biz_admin"), &Nothing{})
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+
+	expected := []struct{ consumer, method string }{
+		{"biz_user", "/main.Biz/Add"},
+		{"biz_admin", "/main.Biz/Check"},
+		{"biz_user", "/main.Biz/Check"},
+		{"biz_admin", "/main.Biz/Add"},
+		{"biz_user", "/main.Biz/Check"},
+	}
+	for _, exp := range expected {
+		evt, err := all.Recv()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if evt.Consumer != exp.consumer || evt.Method != exp.method {
+			t.Fatalf("unfiltered stream: expected %s %s, have %+v", exp.consumer, exp.method, evt)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		evt, err := filtered.Recv()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if evt.Consumer != "biz_user" || evt.Method != "/main.Biz/Check" {
+			t.Fatalf("filtered stream got a call it should not: %+v", evt)
+		}
+	}
+}
+
+func TestStatIntervalValidation(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	for _, interval := range []*StatInterval{
+		{},
+		{IntervalSeconds: 365 * 24 * 3600},
+	} {
+		statStream, err := adm.Statistics(getConsumerCtx("stat"), interval)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = statStream.Recv()
+		if code := grpc.Code(err); code != codes.InvalidArgument {
+			t.Fatalf("expected InvalidArgument for %+v, got %v", interval, err)
+		}
+	}
+
+	// the server is still fine
+	statStream, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalMs: 50})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := statStream.Recv(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestStatisticsInteractive(t *testing.T) {
+	acl := `{"stat": ["/main.Admin/StatisticsInteractive"]}`
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, acl)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	statCtx, statCancel := context.WithCancel(getConsumerCtx("stat"))
+	defer statCancel()
+	stream, err := adm.StatisticsInteractive(statCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the time until the next report, which has to be close to want
+	nextAfter := func(want time.Duration) {
+		start := time.Now()
+		if _, err := stream.Recv(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if took := time.Since(start); took < want-50*time.Millisecond || took > want+150*time.Millisecond {
+			t.Fatalf("expected a report after %v, got one after %v", want, took)
+		}
+	}
+
+	if err := stream.Send(&StatInterval{IntervalMs: 400}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nextAfter(400 * time.Millisecond)
+
+	if err := stream.Send(&StatInterval{IntervalMs: 100}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nextAfter(100 * time.Millisecond)
+	nextAfter(100 * time.Millisecond)
+
+	// paused for 300ms, then resumed
+	if err := stream.Send(&StatInterval{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		stream.Send(&StatInterval{IntervalMs: 100})
+	}()
+	nextAfter(400 * time.Millisecond)
+}
+
+func TestIncomingBufferSize(t *testing.T) {
+	const burst = 5
+
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData, WithIncomingBufferSize(burst))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	// the active consumers lock stalls statsSender on the first message
+	srv.activeMu.Lock()
+	defer srv.activeMu.Unlock()
+
+	for i := 0; i < burst+1; i++ {
+		callCtx, cancel := context.WithTimeout(getConsumerCtx("biz_user"), 500*time.Millisecond)
+		_, err := biz.Check(callCtx, &Nothing{})
+		cancel()
+		if err != nil {
+			t.Fatalf("call %d blocked on the fan-out: %v", i, err)
+		}
+	}
+}
+
+func TestStatErrors(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData, WithCountDeniedCalls())
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	statStream, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	// a handler failing on its own
+	failing := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, grpc.Errorf(codes.Internal, "boom")
+	}
+	callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("consumer", "biz_admin"))
+	srv.unaryInterceptor(callCtx, &Nothing{}, &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Test"}, failing)
+
+	// denied by the ACL
+	biz.Test(getConsumerCtx("biz_user"), &Nothing{})
+
+	// and a good one
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+
+	stat, err := statStream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedErrByMethod := map[string]uint64{
+		"/main.Biz/Test": 2,
+	}
+	expectedErrByConsumer := map[string]uint64{
+		"biz_admin": 1,
+		"biz_user":  1,
+	}
+	if !reflect.DeepEqual(stat.ErrorsByMethod, expectedErrByMethod) {
+		t.Fatalf("errors by method dont match\nhave %+v\nwant %+v", stat.ErrorsByMethod, expectedErrByMethod)
+	}
+	if !reflect.DeepEqual(stat.ErrorsByConsumer, expectedErrByConsumer) {
+		t.Fatalf("errors by consumer dont match\nhave %+v\nwant %+v", stat.ErrorsByConsumer, expectedErrByConsumer)
+	}
+	if stat.ByMethod["/main.Biz/Check"] != 1 || stat.ErrorsByMethod["/main.Biz/Check"] != 0 {
+		t.Fatalf("successful call miscounted: %+v", stat)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("consumer", "biz_admin"))
+
+	panicking := func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	}
+	_, err = srv.unaryInterceptor(callCtx, &Nothing{}, &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Test"}, panicking)
+	if code := grpc.Code(err); code != codes.Internal {
+		t.Fatalf("expected Internal code, got %v", err)
+	}
+
+	panickingStream := func(srv interface{}, stream grpc.ServerStream) error {
+		panic("boom")
+	}
+	statCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("consumer", "stat"))
+	err = srv.streamInterceptor(nil, &serverStreamWithContext{ctx: statCtx},
+		&grpc.StreamServerInfo{FullMethod: "/main.Admin/Statistics"}, panickingStream)
+	if code := grpc.Code(err); code != codes.Internal {
+		t.Fatalf("expected Internal code for stream, got %v", err)
+	}
+	wait(1)
+
+	// the failed call is still recorded
+	if l := srv.Latencies()["/main.Biz/Test"]; l.Count != 1 {
+		t.Fatalf("expected the panicked call to be recorded, have %+v", l)
+	}
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	if _, err := biz.Test(getConsumerCtx("biz_admin"), &Nothing{}); err != nil {
+		t.Fatalf("server stopped serving after a panic: %v", err)
+	}
+}
+
+func TestStreamCallsEmittedOnce(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	logStream1, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+	statStream1, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	logStream2, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	statStream2, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	// marks the end of what we look at in the log streams
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+
+	readLogs := func(stream Admin_LoggingClient) []string {
+		var methods []string
+		for {
+			evt, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			methods = append(methods, evt.Method)
+			if evt.Method == "/main.Biz/Check" {
+				return methods
+			}
+		}
+	}
+
+	expected := []string{"/main.Admin/Logging", "/main.Biz/Check"}
+	if have := readLogs(logStream1); !reflect.DeepEqual(have, expected) {
+		t.Fatalf("logs1 dont match\nhave %+v\nwant %+v", have, expected)
+	}
+	expected = []string{"/main.Biz/Check"}
+	if have := readLogs(logStream2); !reflect.DeepEqual(have, expected) {
+		t.Fatalf("logs2 dont match\nhave %+v\nwant %+v", have, expected)
+	}
+
+	stat1, err := statStream1.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := stat1.ByMethod["/main.Admin/Statistics"]; n != 1 {
+		t.Fatalf("expected the second Statistics call counted once, have %d", n)
+	}
+	stat2, err := statStream2.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := stat2.ByMethod["/main.Admin/Statistics"]; n != 0 {
+		t.Fatalf("a Statistics stream should not count itself, have %d", n)
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	acl := `{
+	"biz_user": ["/main.Biz/Check"],
+	"admin":    ["/main.Admin/Snapshot"]
+}`
+	srv, conn, stop := startTestServer(t, acl, WithHandlerTimeout(50*time.Millisecond))
+	defer stop()
+	defer conn.Close()
+
+	slow := func(ctx context.Context, req interface{}) (interface{}, error) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			return &Nothing{}, nil
+		case <-ctx.Done():
+			return nil, contextError(ctx)
+		}
+	}
+	call := func(consumer, method string) error {
+		callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("consumer", consumer))
+		_, err := srv.unaryInterceptor(callCtx, &Nothing{}, &grpc.UnaryServerInfo{FullMethod: method}, slow)
+		return err
+	}
+
+	start := time.Now()
+	err := call("biz_user", "/main.Biz/Check")
+
+	if code := grpc.Code(err); code != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded code, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("expected the handler to be cut off after 50ms, it took %v", elapsed)
+	}
+
+	// admin methods are not limited
+	if err := call("admin", "/main.Admin/Snapshot"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// and the server is fine
+	if _, err := NewBizClient(conn).Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	health, err := NewAdminClient(conn).Health(context.Background(), &Nothing{})
+	if err != nil || health.Status != HealthStatus_SERVING {
+		t.Fatalf("expected SERVING, got %v %v", health, err)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData, WithIdempotency(time.Minute, 0))
+	defer stop()
+	defer conn.Close()
+
+	var runs int32
+	fail := int32(0)
+	release := make(chan struct{})
+	close(release)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		n := atomic.AddInt32(&runs, 1)
+		<-release
+		if atomic.LoadInt32(&fail) == 1 {
+			return nil, status.Error(codes.Unavailable, "try again")
+		}
+		return &Nothing{Dummy: n == 1}, nil
+	}
+	call := func(consumer, key string) (*Nothing, error) {
+		callCtx := metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs("consumer", consumer, "idempotency-key", key))
+		resp, err := srv.unaryInterceptor(callCtx, &Nothing{}, &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Add"}, handler)
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*Nothing), nil
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := call("biz_user", "k1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !resp.Dummy {
+			t.Fatalf("expected the response of the first call, have %+v", resp)
+		}
+	}
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Fatalf("expected the handler to run once, it ran %d times", n)
+	}
+
+	// keys are per consumer
+	if _, err := call("biz_admin", "k1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&runs); n != 2 {
+		t.Fatalf("expected another consumer's key to run the handler, runs %d", n)
+	}
+
+	// a failed call is not remembered, its retry runs
+	atomic.StoreInt32(&fail, 1)
+	if _, err := call("biz_user", "k2"); grpc.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+	atomic.StoreInt32(&fail, 0)
+	if _, err := call("biz_user", "k2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&runs); n != 4 {
+		t.Fatalf("expected the retry after a failure to run, runs %d", n)
+	}
+
+	// a repeat while the first call runs waits for it
+	atomic.StoreInt32(&runs, 0)
+	release = make(chan struct{})
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := call("biz_user", "k3")
+			errs <- err
+		}()
+	}
+	wait(5)
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Fatalf("expected concurrent repeats to run the handler once, it ran %d times", n)
+	}
+}
+
+func TestIdempotencyStoreBounded(t *testing.T) {
+	st := newIdempotencyStore(time.Minute, 4)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		key := idempotencyKey{consumer: "biz_user", method: "/main.Biz/Add", key: fmt.Sprint(i)}
+		call, first := st.begin(key, now)
+		if !first {
+			t.Fatalf("expected key %d to be new", i)
+		}
+		st.finish(key, call, &Nothing{}, nil)
+		if len(st.calls) > 4 {
+			t.Fatalf("store grew to %d keys over its size of 4", len(st.calls))
+		}
+	}
+}
+
+func TestCancelledBeforeHandler(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	called := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		return &Nothing{}, nil
+	}
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	callCtx := metadata.NewIncomingContext(cancelledCtx, metadata.Pairs("consumer", "biz_user"))
+
+	_, err = srv.unaryInterceptor(callCtx, &Nothing{}, &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Check"}, handler)
+	if code := grpc.Code(err); code != codes.Canceled {
+		t.Fatalf("expected Canceled code, got %v", err)
+	}
+	if called {
+		t.Fatalf("handler ran for a cancelled call")
+	}
+
+	expiredCtx, expire := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer expire()
+
+	_, err = srv.Check(expiredCtx, &Nothing{})
+	if code := grpc.Code(err); code != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded code from the handler, got %v", err)
+	}
+}
+
+func TestBizEcho(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	calls := map[string]func(context.Context, *Nothing, ...grpc.CallOption) (*Nothing, error){
+		"Check": biz.Check,
+		"Add":   biz.Add,
+		"Test":  biz.Test,
+	}
+
+	for name, call := range calls {
+		for _, dummy := range []bool{true, false} {
+			resp, err := call(getConsumerCtx("biz_admin"), &Nothing{Dummy: dummy})
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+			if resp.Dummy != dummy {
+				t.Fatalf("%s: expected dummy %v echoed, have %v", name, dummy, resp.Dummy)
+			}
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	// no consumer needed
+	resp, err := adm.Health(context.Background(), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != HealthStatus_SERVING {
+		t.Fatalf("expected SERVING, have %v", resp.Status)
+	}
+
+	finish()
+	wait(1)
+
+	// the connection is gone by now, ask the handler directly
+	resp, err = srv.Health(context.Background(), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != HealthStatus_NOT_SERVING {
+		t.Fatalf("expected NOT_SERVING after cancel, have %v", resp.Status)
+	}
+}
+
+func TestTokenValidator(t *testing.T) {
+	tokens := map[string]string{
+		"user-token":  "biz_user",
+		"admin-token": "biz_admin",
+	}
+	// the call context carries the peer, a validator may check it
+	validator := func(ctx context.Context, token string) (string, error) {
+		if _, ok := peer.FromContext(ctx); !ok {
+			return "", fmt.Errorf("no peer in the call context")
+		}
+		consumer, ok := tokens[token]
+		if !ok {
+			return "", fmt.Errorf("unknown token")
+		}
+		return consumer, nil
+	}
+
+	biz, _, cleanup := startTestService(t, ACLData, WithTokenValidator(validator))
+	defer cleanup()
+
+	withToken := func(token string, consumer string) context.Context {
+		md := metadata.Pairs("authorization", "Bearer "+token)
+		if consumer != "" {
+			md.Set("consumer", consumer)
+		}
+		return metadata.NewOutgoingContext(context.Background(), md)
+	}
+
+	type call func(context.Context, *Nothing, ...grpc.CallOption) (*Nothing, error)
+	cases := []struct {
+		name string
+		call call
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"valid token", biz.Check, withToken("user-token", ""), codes.OK},
+		// biz_user may not call Test whatever the header claims
+		{"spoofed header", biz.Test, withToken("user-token", "biz_admin"), codes.PermissionDenied},
+		{"admin token", biz.Test, withToken("admin-token", ""), codes.OK},
+		{"unknown token", biz.Check, withToken("stolen-token", "biz_admin"), codes.Unauthenticated},
+		{"header only", biz.Check, getConsumerCtx("biz_admin"), codes.Unauthenticated},
+		{"not bearer", biz.Check, metadata.NewOutgoingContext(context.Background(),
+			metadata.Pairs("authorization", "Basic dXNlcg==")), codes.Unauthenticated},
+	}
+
+	for _, c := range cases {
+		_, err := c.call(c.ctx, &Nothing{})
+		if code := grpc.Code(err); code != c.code {
+			t.Errorf("%s: expected %v, got %v", c.name, c.code, err)
+		}
+	}
+}
+
+func TestPipelinesDisabled(t *testing.T) {
+	acl := `{
+	"admin":    ["/main.Admin/*"],
+	"biz_user": ["/main.Biz/Check"]
+}`
+	srv, conn, stop := startTestServer(t, acl, WithLoggingDisabled(), WithStatsDisabled())
+	defer stop()
+	defer conn.Close()
+
+	// nobody reads the incoming channels, a single send would hang
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &Nothing{}, nil
+	}
+	callCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("consumer", "biz_user"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/main.Biz/Check"}
+
+	const calls = 1000
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < calls; i++ {
+			if _, err := srv.unaryInterceptor(callCtx, &Nothing{}, info, handler); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("interceptor blocked on a disabled pipeline")
+	}
+
+	adm := NewAdminClient(conn)
+
+	logStream, err := adm.Logging(getConsumerCtx("admin"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := logStream.Recv(); grpc.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected Unimplemented from Logging, got %v", err)
+	}
+	statStream, err := adm.Statistics(getConsumerCtx("admin"), &StatInterval{IntervalSeconds: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := statStream.Recv(); grpc.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected Unimplemented from Statistics, got %v", err)
+	}
+	if _, err := adm.Snapshot(getConsumerCtx("admin"), &Nothing{}); grpc.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected Unimplemented from Snapshot, got %v", err)
+	}
+
+	// AuditAccess does not depend on either, it still ends on shutdown
+	auditStream, err := adm.AuditAccess(getConsumerCtx("admin"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+	if _, err := NewBizClient(conn).Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := auditStream.Recv(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	go stop()
+	select {
+
+	case <-srv.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not stop")
+	}
+	if _, err := auditStream.Recv(); err != io.EOF {
+		t.Fatalf("expected the audit stream to end, got %v", err)
+	}
+}
+
+func TestMultipleConsumers(t *testing.T) {
+	md := func(consumers ...string) context.Context {
+		pairs := []string{}
+		for _, c := range consumers {
+			pairs = append(pairs, "consumer", c)
+		}
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+	}
+
+	cases := []struct {
+		name     string
+		ctx      context.Context
+		policy   ConsumerPolicy
+		consumer string
+		code     codes.Code
+	}{
+		{"zero", md(), RejectMultipleConsumers, "", codes.Unauthenticated},
+		{"one", md("biz_user"), RejectMultipleConsumers, "biz_user", codes.OK},
+		{"duplicate", md("biz_user", "biz_user"), RejectMultipleConsumers, "biz_user", codes.OK},
+		{"conflicting", md("biz_user", "biz_admin"), RejectMultipleConsumers, "", codes.Unauthenticated},
+		{"conflicting first", md("biz_user", "biz_admin"), FirstConsumer, "biz_user", codes.OK},
+		{"zero first", md(), FirstConsumer, "", codes.Unauthenticated},
+	}
+
+	for _, c := range cases {
+		srv := &service{cfg: Config{MultipleConsumers: c.policy}}
+		consumer, err := srv.getConsumerNameFromContext(c.ctx)
+		if code := grpc.Code(err); code != c.code {
+			t.Errorf("%s: expected %v, got %v", c.name, c.code, err)
+		}
+		if consumer != c.consumer {
+			t.Errorf("%s: expected consumer %q, have %q", c.name, c.consumer, consumer)
+		}
+	}
+}
+
+func TestStreamStats(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData)
+	defer stop()
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	logCtx, logCancel := context.WithCancel(getConsumerCtx("logger"))
+	logStream, err := adm.Logging(logCtx, &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the stream is open for at least the 10ms the duration is checked against
+	waitListeners(t, srv, 1, 0)
+	wait(1)
+
+	for i := 0; i < 3; i++ {
+		biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+		if _, err := logStream.Recv(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	logCancel()
+	wait(1)
+
+	st, ok := srv.StreamStats()["/main.Admin/Logging"]
+	if !ok {
+		t.Fatalf("finished stream is not recorded")
+	}
+	// the request itself is the one received message
+	if st.Streams != 1 || st.Sent != 3 || st.Received != 1 {
+		t.Fatalf("unexpected stream stats: %+v", st)
+	}
+	if st.Duration < 10*time.Millisecond || st.Longest != st.Duration {
+		t.Fatalf("unexpected stream duration: %+v", st)
+	}
+}
+
+func TestMaxAdminStreams(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData, WithMaxAdminStreams(2))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	logCtx1, cancelLog1 := context.WithCancel(getConsumerCtx("logger"))
+	defer cancelLog1()
+	logStream1, err := adm.Logging(logCtx1, &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logStream2, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	// the third one is refused, the two before it keep going
+	logStream3, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := logStream3.Recv(); grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted code, got %v", err)
+	}
+
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, stream := range []Admin_LoggingClient{logStream1, logStream2} {
+		// logStream1 sees logStream2 and logStream3 being opened first
+		for {
+			evt, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if evt.Method == "/main.Biz/Check" {
+				break
+			}
+		}
+	}
+
+	// a closed stream frees its place
+	cancelLog1()
+	wait(1)
+
+	logStream4, err := adm.Logging(getConsumerCtx("logger"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	evt, err := logStream4.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if evt.Method != "/main.Biz/Check" {
+		t.Fatalf("expected the Check event, have %+v", evt)
+	}
+
+	// statistics streams have a limit of their own
+	for i := 0; i < 2; i++ {
+		_, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalMs: 20})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	wait(1)
+	statStream, err := adm.Statistics(getConsumerCtx("stat"), &StatInterval{IntervalMs: 20})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := statStream.Recv(); grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted code, got %v", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	acl := `{
+	"biz_user": ["/main.Biz/Check", "/main.Biz/Add"],
+	"reader":   ["/main.Admin/Snapshot"]
+}`
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, acl)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	wait(1)
+
+	stat, err := adm.Snapshot(getConsumerCtx("reader"), &Nothing{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := &Stat{
+		Timestamp: stat.Timestamp,
+		ByMethod: map[string]uint64{
+			"/main.Biz/Check": 2,
+			"/main.Biz/Add":   1,
+		},
+		ByConsumer: map[string]uint64{
+			"biz_user": 3,
+		},
+	}
+	if !reflect.DeepEqual(stat, expected) {
+		t.Fatalf("snapshot dont match\nhave %+v\nwant %+v", stat, expected)
+	}
+
+	_, err = adm.Snapshot(getConsumerCtx("biz_user"), &Nothing{})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied code, got %v", code)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData, WithRateLimit(1, 3))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	var allowed, limited int
+	for i := 0; i < 6; i++ {
+		_, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+		switch code := grpc.Code(err); code {
+		case codes.OK:
+			allowed++
+		case codes.ResourceExhausted:
+			limited++
+		default:
+			t.Fatalf("unexpected code %v", code)
+		}
+	}
+	if allowed != 3 || limited != 3 {
+		t.Fatalf("expected 3 allowed and 3 limited calls, have %d and %d", allowed, limited)
+	}
+
+	// other consumers have their own budget
+	if _, err := biz.Check(getConsumerCtx("biz_admin"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wait(1)
+
+	// limited calls are still counted
+	if d := srv.Diagnostics(); d.ByMethod["/main.Biz/Check"] != 7 {
+		t.Fatalf("expected all 7 calls to be counted, have %+v", d.ByMethod)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	rl := newRateLimiter(10, 10)
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		if !rl.allow("a", now) {
+			t.Fatalf("call %d should fit in the burst", i)
+		}
+	}
+	if rl.allow("a", now) {
+		t.Fatalf("expected the bucket to be empty")
+	}
+
+	// refilled in a second, idle buckets go away
+	later := now.Add(2 * time.Second)
+	if !rl.allow("b", later) {
+		t.Fatalf("expected a fresh bucket for b")
+	}
+	if _, ok := rl.buckets["a"]; ok {
+		t.Fatalf("expected the idle bucket to be swept")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData, WithMetrics(), WithCountDeniedCalls())
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Test(getConsumerCtx("biz_user"), &Nothing{})
+	wait(1)
+
+	rec := httptest.NewRecorder()
+	srv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	expected := []string{
+		`hw7_requests_total{method="/main.Biz/Check",consumer="biz_user",code="OK"} 2`,
+		`hw7_requests_total{method="/main.Biz/Test",consumer="biz_user",code="PermissionDenied"} 1`,
+		`hw7_handler_latency_seconds_bucket{method="/main.Biz/Check",le="+Inf"} 2`,
+		`hw7_handler_latency_seconds_count{method="/main.Biz/Check"} 2`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("metrics miss %q\n%s", line, body)
+		}
+	}
+}
+
+func TestListenerCount(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData, WithMetrics())
+	defer stop()
+	defer conn.Close()
+
+	adm := NewAdminClient(conn)
+
+	waitListeners(t, srv, 0, 0)
+
+	logCtx1, logCancel1 := context.WithCancel(getConsumerCtx("logger"))
+	defer logCancel1()
+	if _, err := adm.Logging(logCtx1, &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logCtx2, logCancel2 := context.WithCancel(getConsumerCtx("logger"))
+	defer logCancel2()
+	if _, err := adm.Logging(logCtx2, &Nothing{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	statCtx, statCancel := context.WithCancel(getConsumerCtx("stat"))
+	defer statCancel()
+	if _, err := adm.Statistics(statCtx, &StatInterval{IntervalSeconds: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waitListeners(t, srv, 2, 1)
+
+	rec := httptest.NewRecorder()
+	srv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{"hw7_listeners 2", "hw7_stat_listeners 1"} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("metrics miss %q\n%s", line, body)
+		}
+	}
+
+	logCancel1()
+	waitListeners(t, srv, 1, 1)
+
+	logCancel2()
+	statCancel()
+	waitListeners(t, srv, 0, 0)
+}
+
+func TestAddListenerValidates(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData)
+	defer stop()
+	defer conn.Close()
+
+	l := &listener{
+		logsCh:  make(chan *logMsg, 10),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	if _, err := srv.addListener(l); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer srv.streams.Done()
+	defer srv.removeListener(l)
+
+	if _, err := srv.addListener(l); err != errListenerRegistered {
+		t.Fatalf("expected errListenerRegistered, got %v", err)
+	}
+	if _, err := srv.addListener(&listener{closeCh: make(chan struct{}), doneCh: make(chan struct{})}); err != errListenerInvalid {
+		t.Fatalf("expected errListenerInvalid, got %v", err)
+	}
+	if n := srv.ListenerCount(); n != 1 {
+		t.Fatalf("expected 1 listener, have %d", n)
+	}
+
+	// the fan-out sends to the listener once
+	srv.broadcastLog(&logMsg{consumerName: "biz_user", methodName: "/main.Biz/Check"})
+	<-l.logsCh
+	select {
+	case log := <-l.logsCh:
+		t.Fatalf("expected a single copy of the message, have another %+v", log)
+	default:
+	}
+
+	sl := &statListener{
+		statCh:  make(chan *statMsg, 10),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	if err := srv.addStatListener(sl); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer srv.streams.Done()
+	defer srv.removeStatListener(sl)
+
+	if err := srv.addStatListener(sl); err != errListenerRegistered {
+		t.Fatalf("expected errListenerRegistered, got %v", err)
+	}
+	if err := srv.addStatListener(&statListener{closeCh: make(chan struct{}), doneCh: make(chan struct{})}); err != errListenerInvalid {
+		t.Fatalf("expected errListenerInvalid, got %v", err)
+	}
+	if n := srv.StatListenerCount(); n != 1 {
+		t.Fatalf("expected 1 stat listener, have %d", n)
+	}
+}
+
+func TestRegisterWhileListenerStuck(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData)
+	defer stop()
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	// a listener that never reads and blocks the fan-out once it is full
+	stuck := &listener{
+		logsCh:  make(chan *logMsg, 1),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+		mode:    BlockOnFull,
+	}
+	if _, err := srv.addListener(stuck); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	srv.streams.Done()
+
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	wait(5)
+
+	registered := make(chan error, 1)
+	fresh := &listener{
+		logsCh:  make(chan *logMsg, 10),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	go func() {
+		_, err := srv.addListener(fresh)
+		registered <- err
+	}()
+	select {
+	case err := <-registered:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("a new listener could not register while another was stuck")
+	}
+	defer srv.streams.Done()
+	defer srv.removeListener(fresh)
+	defer close(fresh.doneCh)
+
+	// nor is anything else taking the lock held up
+	statCtx, statCancel := context.WithCancel(getConsumerCtx("stat"))
+	defer statCancel()
+	if _, err := adm.Statistics(statCtx, &StatInterval{IntervalSeconds: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for srv.StatListenerCount() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("a Statistics stream could not register while a listener was stuck")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// once the stuck one is gone the new one gets the events
+	close(stuck.doneCh)
+	biz.Add(getConsumerCtx("biz_user"), &Nothing{})
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case log := <-fresh.logsCh:
+			if log.methodName == "/main.Biz/Add" {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("the new listener got no events")
+		}
+	}
+}
+
+func TestListenerChurn(t *testing.T) {
+	srv, conn, stop := startTestServer(t, ACLData, WithListenerBufferSize(1))
+	defer stop()
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+	adm := NewAdminClient(conn)
+
+	quit := make(chan struct{})
+	errs := make(chan error, 100)
+	wg := &sync.WaitGroup{}
+
+	// calls keep the fan-out busy
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-quit:
+					return
+				default:
+				}
+				if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	// streams come and go, some read a bit and some nothing
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-quit:
+					return
+				default:
+				}
+
+				consumer := "logger"
+				if i%2 == 1 {
+					consumer = "stat"
+				}
+				// the calls may be over before a Logging stream gets one
+				streamCtx, cancel := context.WithTimeout(getConsumerCtx(consumer), 200*time.Millisecond)
+				var recv func() error
+				if i%2 == 0 {
+					stream, err := adm.Logging(streamCtx, &Nothing{})
+					if err != nil {
+						cancel()
+						errs <- err
+						return
+					}
+					recv = func() error { _, err := stream.Recv(); return err }
+				} else {
+					stream, err := adm.Statistics(streamCtx, &StatInterval{IntervalMs: 1})
+					if err != nil {
+						cancel()
+						errs <- err
+						return
+					}
+					recv = func() error { _, err := stream.Recv(); return err }
+				}
+				if n%2 == 0 {
+					if err := recv(); err != nil && grpc.Code(err) != codes.DeadlineExceeded {
+						cancel()
+						errs <- err
+						return
+					}
+				}
+				cancel()
+			}
+		}(i)
+	}
+
+	time.Sleep(time.Second)
+	close(quit)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected error: %v", err)
+	default:
+	}
+
+	// no listener is left behind
+	waitListeners(t, srv, 0, 0)
+
+	if _, err := biz.Check(getConsumerCtx("biz_user"), &Nothing{}); err != nil {
+		t.Fatalf("unexpected error after the churn: %v", err)
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	srv, err := startService(ctx, listenAddr, ACLData)
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	rec := httptest.NewRecorder()
+	srv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without WithMetrics, got %d", rec.Code)
+	}
+}
+
+func TestAuditWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	ctx, finish := context.WithCancel(context.Background())
+	ms, err := StartMyMicroservice(ctx, listenAddr, ACLData, WithAuditWriter(buf))
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	biz := NewBizClient(conn)
+
+	start := time.Now()
+	biz.Check(getConsumerCtx("biz_user"), &Nothing{})
+	biz.Test(getConsumerCtx("biz_user"), &Nothing{})
+
+	finish()
+	ms.Wait()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit lines, have %d:\n%s", len(lines), buf.String())
+	}
+
+	expected := []struct {
+		method string
+		code   string
+	}{
+		{"/main.Biz/Check", "OK"},
+		{"/main.Biz/Test", "PermissionDenied"},
+	}
+	for i, line := range lines {
+		var rec struct {
+			Timestamp  time.Time `json:"timestamp"`
+			Consumer   string    `json:"consumer"`
+			Method     string    `json:"method"`
+			Peer       string    `json:"peer"`
+			Code       string    `json:"code"`
+			DurationMs *float64  `json:"duration_ms"`
+		}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("audit line %d is not JSON: %v\n%s", i, err, line)
+		}
+		if rec.Consumer != "biz_user" || rec.Method != expected[i].method || rec.Code != expected[i].code {
+			t.Fatalf("unexpected audit line %d: %s", i, line)
+		}
+		if rec.Timestamp.Before(start.Add(-time.Second)) || !strings.HasPrefix(rec.Peer, "127.0.0.1:") || rec.DurationMs == nil {
+			t.Fatalf("unexpected audit line %d: %s", i, line)
+		}
+	}
+}
+
+func TestAuditWriterValidatesTokenOnce(t *testing.T) {
+	var validated int32
+	validator := func(ctx context.Context, token string) (string, error) {
+		atomic.AddInt32(&validated, 1)
+		return "biz_user", nil
+	}
+
+	buf := &bytes.Buffer{}
+	biz, _, cleanup := startTestService(t, ACLData, WithTokenValidator(validator), WithAuditWriter(buf))
+
+	md := metadata.Pairs("authorization", "Bearer user-token")
+	_, err := biz.Check(metadata.NewOutgoingContext(context.Background(), md), &Nothing{})
+	// the record is read once the server is stopped
+	cleanup()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&validated); n != 1 {
+		t.Fatalf("expected the token to be validated once, have %d", n)
+	}
+	var rec struct {
+		Consumer string `json:"consumer"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil || rec.Consumer != "biz_user" {
+		t.Fatalf("unexpected audit record: %s", buf.String())
+	}
+}
+
+func TestReflection(t *testing.T) {
+	ctx, finish := context.WithCancel(context.Background())
+	_, err := startService(ctx, listenAddr, ACLData, WithReflection())
+	if err != nil {
+		t.Fatalf("cant start server initial: %v", err)
+	}
+	wait(1)
+	defer func() {
+		finish()
+		wait(1)
+	}()
+
+	conn := getGrpcConn(t)
+	defer conn.Close()
+
+	// no consumer, like grpcurl would call it
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = stream.Send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	services := map[string]bool{}
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		services[svc.Name] = true
+	}
+	if !services["main.Biz"] || !services["main.Admin"] {
+		t.Fatalf("expected Biz and Admin to be listed, have %v", services)
+	}
+}
+
 func __dummyLog() {
 	fmt.Println(1)
 	log.Println(1)